@@ -0,0 +1,142 @@
+package httpserver
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AfterResponseInfo struct {
+	Path         string
+	Status       int
+	Duration     time.Duration
+	RequestSize  int64
+	ResponseSize int
+	Err          error
+	Canary       string
+}
+
+// afterResponsePool runs the after response hook on a bounded set of
+// workers, infos are dropped when the queue is full or the pool is closed
+type afterResponsePool struct {
+	hook   func(AfterResponseInfo)
+	queue  chan AfterResponseInfo
+	drain  bool
+	logger Logger
+	mu     sync.RWMutex
+	closed bool
+	wg     sync.WaitGroup
+}
+
+func newAfterResponsePool(hook func(AfterResponseInfo), workers int, queueSize int, drain bool, logger Logger) *afterResponsePool {
+	p := &afterResponsePool{
+		hook:   hook,
+		queue:  make(chan AfterResponseInfo, queueSize),
+		drain:  drain,
+		logger: logger,
+	}
+
+	for i := 0; i < workers; i++ {
+		p.wg.Add(1)
+		go p.work()
+	}
+
+	return p
+}
+
+func (p *afterResponsePool) Submit(info AfterResponseInfo) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.closed {
+		return false
+	}
+
+	select {
+	case p.queue <- info:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close stops accepting infos, discards the queued ones unless the pool
+// drains, and waits for the running hooks to return
+func (p *afterResponsePool) Close() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	if !p.drain {
+	discard:
+		for {
+			select {
+			case <-p.queue:
+			default:
+				break discard
+			}
+		}
+	}
+	close(p.queue)
+	p.mu.Unlock()
+
+	p.wg.Wait()
+}
+
+func (p *afterResponsePool) work() {
+	defer p.wg.Done()
+
+	for info := range p.queue {
+		p.run(info)
+	}
+}
+
+func (p *afterResponsePool) run(info AfterResponseInfo) {
+	defer func() {
+		if v := recover(); v != nil {
+			p.logger.Error("after response hook panic", "path", info.Path, "panic", v)
+		}
+	}()
+
+	p.hook(info)
+}
+
+// afterResponseKey marks a request whose hook is submitted by an outer
+// AfterResponse, it lives on the request context as HandleContext resets
+// the gin keys
+type afterResponseKey struct{}
+
+func (e *Engine) AfterResponse(c *gin.Context) {
+	if e.afterResponse == nil || c.Request.Context().Value(afterResponseKey{}) != nil {
+		return
+	}
+	c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), afterResponseKey{}, true))
+
+	start := time.Now()
+
+	c.Next()
+
+	var err error
+	if v, ok := c.Get(PanicContext); ok && v != nil {
+		err = v.(error)
+	} else if v, ok := c.Get(ErrorContext); ok && v != nil {
+		err = v.(error)
+	}
+
+	info := AfterResponseInfo{
+		Path:         c.Request.URL.Path,
+		Status:       c.Writer.Status(),
+		Duration:     time.Since(start),
+		RequestSize:  c.Request.ContentLength,
+		ResponseSize: c.Writer.Size(),
+		Err:          err,
+		Canary:       c.GetString(CanaryContext),
+	}
+	if !e.afterResponse.Submit(info) {
+		e.Logger.Warn("after response hook dropped", "path", info.Path)
+	}
+}
@@ -0,0 +1,83 @@
+package httpserver
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// recordHooks collects the infos reported by the after response hook
+type recordHooks struct {
+	mu    sync.Mutex
+	infos []AfterResponseInfo
+}
+
+func (r *recordHooks) hook(info AfterResponseInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.infos = append(r.infos, info)
+}
+
+// take stops e, draining the queued hooks, and returns what they reported
+func (r *recordHooks) take(e *Engine) []AfterResponseInfo {
+	e.Stop()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.infos
+}
+
+func TestAfterResponse(t *testing.T) {
+	pkg := testPackage(t)
+	tunnel := funcTunnel(func(route string, req string) string {
+		switch route {
+		case "/jump":
+			return "path://api/" + pkg + "/v1/echo"
+		case "/panic":
+			panic("boom")
+		}
+		return "echo"
+	})
+
+	for _, tc := range []struct {
+		name   string
+		target string
+		status int
+		err    string
+	}{
+		{"direct", "/api/" + pkg + "/v1/echo", http.StatusOK, ""},
+		{"static link", "/echo", http.StatusOK, ""},
+		{"path response", "/api/" + pkg + "/v1/jump", http.StatusOK, ""},
+		{"panic", "/api/" + pkg + "/v1/panic", http.StatusInternalServerError, "boom"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			hooks := &recordHooks{}
+			e := NewEngine(
+				WithAfterResponse(hooks.hook),
+				WithAfterResponseDrain(),
+				WithStaticLink("/echo", "/api/"+pkg+"/v1/echo"),
+				WithStaticPackage(pkg, "v1", tunnel),
+			)
+
+			w := serve(e, http.MethodPost, tc.target, "hello", nil)
+			infos := hooks.take(e)
+			if len(infos) != 1 {
+				t.Fatalf("hook fired %d times", len(infos))
+			}
+
+			info := infos[0]
+			if info.Status != tc.status || info.Status != w.Code {
+				t.Fatalf("status %d, response %d", info.Status, w.Code)
+			}
+			if info.RequestSize != int64(len("hello")) || info.ResponseSize != w.Body.Len() {
+				t.Fatalf("sizes %d %d, response %d", info.RequestSize, info.ResponseSize, w.Body.Len())
+			}
+			if !strings.HasPrefix(info.Path, "/api/"+pkg+"/v1/") {
+				t.Fatalf("path %s", info.Path)
+			}
+			if tc.err == "" && info.Err != nil || tc.err != "" && (info.Err == nil || !strings.Contains(info.Err.Error(), tc.err)) {
+				t.Fatalf("err %v", info.Err)
+			}
+		})
+	}
+}
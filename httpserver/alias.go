@@ -0,0 +1,45 @@
+package httpserver
+
+import "sync"
+
+var (
+	aliasMap = map[string]map[string]string{}
+	muAlias  sync.RWMutex
+)
+
+// SetAlias points alias of the package to a concrete version for every
+// engine in the process, it can be repointed at any time without
+// registering the package again. Aliases set with WithVersionAlias take
+// precedence on their engine.
+func SetAlias(packageName string, alias string, version string) {
+	muAlias.Lock()
+	defer muAlias.Unlock()
+
+	if _, ok := aliasMap[packageName]; !ok {
+		aliasMap[packageName] = map[string]string{}
+	}
+	aliasMap[packageName][alias] = version
+}
+
+func RemoveAlias(packageName string, alias string) {
+	muAlias.Lock()
+	defer muAlias.Unlock()
+
+	delete(aliasMap[packageName], alias)
+}
+
+// resolveAlias resolves the aliases of the engine, then the process wide
+// aliases
+func (e *Engine) resolveAlias(packageName string, commit string) string {
+	if version, ok := e.VersionAliases[packageName][commit]; ok {
+		return version
+	}
+
+	muAlias.RLock()
+	defer muAlias.RUnlock()
+
+	if version, ok := aliasMap[packageName][commit]; ok {
+		return version
+	}
+	return commit
+}
@@ -0,0 +1,57 @@
+package httpserver
+
+import (
+	"net/http"
+	"testing"
+)
+
+func versionTunnel(version string) funcTunnel {
+	return func(route string, req string) string { return version }
+}
+
+func TestVersionAlias(t *testing.T) {
+	pkg := testPackage(t)
+	e := NewEngine(
+		WithStaticPackage(pkg, "v1", versionTunnel("v1")),
+		WithStaticPackage(pkg, "v2", versionTunnel("v2")),
+		WithVersionAlias(pkg, "latest", "v2"),
+		WithVersionAlias(pkg, "stable", "v1"),
+	)
+	other := NewEngine()
+
+	if w := serve(e, http.MethodGet, "/api/"+pkg+"/latest/route", "", nil); w.Code != http.StatusOK || w.Body.String() != "v2" {
+		t.Fatalf("alias: got %d %s", w.Code, w.Body.String())
+	}
+	if w := serve(other, http.MethodGet, "/api/"+pkg+"/latest/route", "", nil); w.Body.String() == "v2" {
+		t.Fatal("alias leaked to another engine")
+	}
+
+	SetAlias(pkg, "stable", "v2")
+	defer RemoveAlias(pkg, "stable")
+
+	if w := serve(e, http.MethodGet, "/api/"+pkg+"/stable/route", "", nil); w.Body.String() != "v1" {
+		t.Fatalf("engine alias: got %s", w.Body.String())
+	}
+	if w := serve(other, http.MethodGet, "/api/"+pkg+"/stable/route", "", nil); w.Body.String() != "v2" {
+		t.Fatalf("process wide alias: got %s", w.Body.String())
+	}
+}
+
+func TestSetAlias(t *testing.T) {
+	pkg := testPackage(t)
+	e := NewEngine(
+		WithStaticPackage(pkg, "v1", versionTunnel("v1")),
+		WithStaticPackage(pkg, "v2", versionTunnel("v2")),
+	)
+	defer RemoveAlias(pkg, "current")
+
+	SetAlias(pkg, "current", "v1")
+	if w := serve(e, http.MethodGet, "/api/"+pkg+"/current/route", "", nil); w.Code != http.StatusOK || w.Body.String() != "v1" {
+		t.Fatalf("alias: got %d %s", w.Code, w.Body.String())
+	}
+
+	SetAlias(pkg, "current", "v2")
+	if w := serve(e, http.MethodGet, "/api/"+pkg+"/current/route", "", nil); w.Code != http.StatusOK || w.Body.String() != "v2" {
+		t.Fatalf("repointed alias: got %d %s", w.Code, w.Body.String())
+	}
+}
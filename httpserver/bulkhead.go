@@ -0,0 +1,60 @@
+package httpserver
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrBulkheadFull = errors.New("too many concurrent requests")
+
+// bulkhead caps the in-flight invocations of each package with a semaphore,
+// packages without a cap are not limited
+type bulkhead struct {
+	slots   map[string]chan struct{}
+	timeout time.Duration
+}
+
+func newBulkhead(limits map[string]int, timeout time.Duration) *bulkhead {
+	slots := map[string]chan struct{}{}
+	for packageName, n := range limits {
+		if n > 0 {
+			slots[packageName] = make(chan struct{}, n)
+		}
+	}
+
+	return &bulkhead{
+		slots:   slots,
+		timeout: timeout,
+	}
+}
+
+// acquire takes a slot of the package and returns its release function,
+// a saturated package is rejected at once or after waiting for the timeout
+func (b *bulkhead) acquire(packageName string) (func(), error) {
+	slot, ok := b.slots[packageName]
+	if !ok {
+		return func() {}, nil
+	}
+
+	release := func() { <-slot }
+
+	select {
+	case slot <- struct{}{}:
+		return release, nil
+	default:
+	}
+
+	if b.timeout > 0 {
+		timer := time.NewTimer(b.timeout)
+		defer timer.Stop()
+
+		select {
+		case slot <- struct{}{}:
+			return release, nil
+		case <-timer.C:
+		}
+	}
+
+	return nil, fmt.Errorf("package %s: %w", packageName, ErrBulkheadFull)
+}
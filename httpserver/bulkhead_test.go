@@ -0,0 +1,112 @@
+package httpserver
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// gateTunnel blocks every invocation until release is closed and records
+// the highest number of concurrent invocations
+type gateTunnel struct {
+	funcTunnel
+	release  chan struct{}
+	inFlight int32
+	peak     int32
+}
+
+func newGateTunnel() *gateTunnel {
+	t := &gateTunnel{release: make(chan struct{})}
+	t.funcTunnel = func(route string, req string) string {
+		n := atomic.AddInt32(&t.inFlight, 1)
+		defer atomic.AddInt32(&t.inFlight, -1)
+		for {
+			peak := atomic.LoadInt32(&t.peak)
+			if n <= peak || atomic.CompareAndSwapInt32(&t.peak, peak, n) {
+				break
+			}
+		}
+		<-t.release
+		return "ok"
+	}
+	return t
+}
+
+// waitInFlight waits until n invocations are blocked in the tunnel
+func (g *gateTunnel) waitInFlight(t *testing.T, n int32) {
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&g.inFlight) != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d invocations in flight, want %d", atomic.LoadInt32(&g.inFlight), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestBulkhead(t *testing.T) {
+	pkg := testPackage(t)
+	tunnel := newGateTunnel()
+	e := NewEngine(WithBulkhead(map[string]int{pkg: 2}), WithStaticPackage(pkg, "v1", tunnel))
+	path := "/api/" + pkg + "/v1/route"
+
+	var wg sync.WaitGroup
+	codes := make(chan int, 2)
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- serve(e, http.MethodGet, path, "", nil).Code
+		}()
+	}
+	tunnel.waitInFlight(t, 2)
+
+	w := serve(e, http.MethodGet, path, "", nil)
+	if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), ErrBulkheadFull.Error()) {
+		t.Fatalf("saturated: got %d %s", w.Code, w.Body.String())
+	}
+
+	close(tunnel.release)
+	wg.Wait()
+	close(codes)
+	for code := range codes {
+		if code != http.StatusOK {
+			t.Fatalf("admitted: got %d", code)
+		}
+	}
+	if w := serve(e, http.MethodGet, path, "", nil); w.Code != http.StatusOK {
+		t.Fatalf("released: got %d", w.Code)
+	}
+}
+
+func TestBulkheadTimeout(t *testing.T) {
+	pkg := testPackage(t)
+	tunnel := newGateTunnel()
+	e := NewEngine(WithBulkhead(map[string]int{pkg: 2}), WithBulkheadTimeout(time.Minute), WithStaticPackage(pkg, "v1", tunnel))
+	path := "/api/" + pkg + "/v1/route"
+
+	// waiting requests queue for a slot, the ceiling always holds
+	var wg sync.WaitGroup
+	var failed int32
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if serve(e, http.MethodGet, path, "", nil).Code != http.StatusOK {
+				atomic.AddInt32(&failed, 1)
+			}
+		}()
+	}
+	tunnel.waitInFlight(t, 2)
+	close(tunnel.release)
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&failed); n != 0 {
+		t.Fatalf("%d requests failed", n)
+	}
+	if peak := atomic.LoadInt32(&tunnel.peak); peak != 2 {
+		t.Fatalf("peak concurrency %d", peak)
+	}
+}
@@ -0,0 +1,74 @@
+package httpserver
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CanaryVersion is the version in the request path which is resolved by
+// the canary weights of the package
+const CanaryVersion = "canary"
+
+// Canary resolves the canary version of the request path to a concrete
+// version and records the choice
+func (e *Engine) Canary(c *gin.Context) {
+	packageName, commit, route, err := parsePath(c.GetString(PathContext))
+	if err != nil || commit != CanaryVersion {
+		return
+	}
+
+	weights, ok := e.Canaries[packageName]
+	if !ok {
+		return
+	}
+
+	version := pickCanary(weights, c.Request.Header.Get(e.CanaryHeader))
+	if version == "" {
+		return
+	}
+
+	c.Set(CanaryContext, version)
+	c.Set(PathContext, fmt.Sprintf("/%s/%s%s", packageName, version, route))
+}
+
+// pickCanary picks a version by weighted random, a sticky key naming one of
+// the versions selects it and any other key always picks the same version
+func pickCanary(weights map[string]int, sticky string) string {
+	if _, ok := weights[sticky]; ok && sticky != "" {
+		return sticky
+	}
+
+	versions := make([]string, 0, len(weights))
+	total := 0
+	for version, weight := range weights {
+		if weight > 0 {
+			versions = append(versions, version)
+			total += weight
+		}
+	}
+	if total == 0 {
+		return ""
+	}
+	sort.Strings(versions)
+
+	var n int
+	if sticky != "" {
+		h := fnv.New32a()
+		h.Write([]byte(sticky))
+		n = int(h.Sum32() % uint32(total))
+	} else {
+		n = rand.Intn(total)
+	}
+
+	for _, version := range versions {
+		if n < weights[version] {
+			return version
+		}
+		n -= weights[version]
+	}
+	return ""
+}
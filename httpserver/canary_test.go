@@ -0,0 +1,39 @@
+package httpserver
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestCanary(t *testing.T) {
+	pkg := testPackage(t)
+	e := NewEngine(
+		WithStaticPackage(pkg, "v1", versionTunnel("v1")),
+		WithStaticPackage(pkg, "v2", versionTunnel("v2")),
+		WithCanary(pkg, map[string]int{"v1": 80, "v2": 20}),
+		WithCanaryHeader("X-User"),
+	)
+	path := "/api/" + pkg + "/canary/route"
+
+	counts := map[string]int{}
+	for i := 0; i < 2000; i++ {
+		counts[serve(e, http.MethodGet, path, "", nil).Body.String()]++
+	}
+	if len(counts) != 2 || counts["v2"] < 300 || counts["v2"] > 500 {
+		t.Fatalf("split %v, want about 1600/400", counts)
+	}
+
+	// a sticky key always lands on the same version
+	first := serve(e, http.MethodGet, path, "", http.Header{"X-User": {"user-1"}}).Body.String()
+	for i := 0; i < 20; i++ {
+		if got := serve(e, http.MethodGet, path, "", http.Header{"X-User": {"user-1"}}).Body.String(); got != first {
+			t.Fatalf("sticky: got %s then %s", first, got)
+		}
+	}
+	// a key naming a version pins it
+	for i := 0; i < 20; i++ {
+		if got := serve(e, http.MethodGet, path, "", http.Header{"X-User": {"v2"}}).Body.String(); got != "v2" {
+			t.Fatalf("pinned: got %s", got)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+	"github.com/ugorji/go/codec"
+)
+
+// Codec assembles the requests passed to tunnels and takes apart their
+// responses, JSON is used unless another codec is configured
+type Codec interface {
+	// Encode encodes the query params of a GET request
+	Encode(v map[string]interface{}) (string, error)
+	// Set adds key to an object payload unless it is present already,
+	// ok is false when the payload is left unchanged
+	Set(payload string, key string, v interface{}) (string, bool)
+	// Extract removes the object under key from an object payload, the
+	// object holds the types JSON decodes to, i.e. numbers are float64 and
+	// nested objects are map[string]interface{}
+	Extract(payload string, key string) (string, map[string]interface{}, bool)
+}
+
+type JSONCodec struct{}
+
+func (JSONCodec) Encode(v map[string]interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func (JSONCodec) Set(payload string, key string, v interface{}) (string, bool) {
+	if !gjson.Valid(payload) || !gjson.Parse(payload).IsObject() || gjson.Get(payload, key).Exists() {
+		return payload, false
+	}
+
+	result, err := sjson.Set(payload, key, v)
+	if err != nil {
+		return payload, false
+	}
+	return result, true
+}
+
+func (JSONCodec) Extract(payload string, key string) (string, map[string]interface{}, bool) {
+	if !gjson.Valid(payload) {
+		return payload, nil, false
+	}
+
+	result := gjson.Get(payload, key)
+	if !result.IsObject() {
+		return payload, nil, false
+	}
+
+	rest, err := sjson.Delete(payload, key)
+	if err != nil {
+		return payload, nil, false
+	}
+
+	v, _ := result.Value().(map[string]interface{})
+	return rest, v, true
+}
+
+var msgpackHandle = func() *codec.MsgpackHandle {
+	h := &codec.MsgpackHandle{}
+	h.MapType = reflect.TypeOf(map[string]interface{}(nil))
+	h.RawToString = true
+	h.WriteExt = true
+	return h
+}()
+
+// MsgPackCodec assembles MessagePack requests, the payload string holds the
+// encoded bytes
+type MsgPackCodec struct{}
+
+func (MsgPackCodec) Encode(v map[string]interface{}) (string, error) {
+	var data []byte
+	if err := codec.NewEncoderBytes(&data, msgpackHandle).Encode(v); err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func (c MsgPackCodec) Set(payload string, key string, v interface{}) (string, bool) {
+	m, ok := c.decode(payload)
+	if !ok {
+		return payload, false
+	}
+	if _, ok := m[key]; ok {
+		return payload, false
+	}
+
+	m[key] = v
+	result, err := c.Encode(m)
+	if err != nil {
+		return payload, false
+	}
+	return result, true
+}
+
+func (c MsgPackCodec) Extract(payload string, key string) (string, map[string]interface{}, bool) {
+	m, ok := c.decode(payload)
+	if !ok {
+		return payload, nil, false
+	}
+
+	v, ok := normalizeValue(m[key]).(map[string]interface{})
+	if !ok {
+		return payload, nil, false
+	}
+
+	delete(m, key)
+	rest, err := c.Encode(m)
+	if err != nil {
+		return payload, nil, false
+	}
+	return rest, v, true
+}
+
+// decode decodes a payload holding exactly one map
+func (MsgPackCodec) decode(payload string) (map[string]interface{}, bool) {
+	var v interface{}
+	decoder := codec.NewDecoderBytes([]byte(payload), msgpackHandle)
+	if err := decoder.Decode(&v); err != nil || decoder.NumBytesRead() != len(payload) {
+		return nil, false
+	}
+
+	m, ok := v.(map[string]interface{})
+	return m, ok
+}
+
+// normalizeValue converts a decoded MessagePack value to the types JSON
+// decodes to
+func normalizeValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case int64:
+		return float64(v)
+	case uint64:
+		return float64(v)
+	case float32:
+		return float64(v)
+	case []byte:
+		return string(v)
+	case []interface{}:
+		for i := range v {
+			v[i] = normalizeValue(v[i])
+		}
+		return v
+	case map[string]interface{}:
+		for k := range v {
+			v[k] = normalizeValue(v[k])
+		}
+		return v
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k := range v {
+			m[fmt.Sprint(k)] = normalizeValue(v[k])
+		}
+		return m
+	default:
+		return v
+	}
+}
+
+// valueType names the type of a decoded value the way response meta
+// types are declared
+func valueType(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case bool:
+		return "bool"
+	case map[string]interface{}, []interface{}:
+		return "json"
+	default:
+		return "number"
+	}
+}
+
+func valueDepth(v interface{}) int {
+	var children []interface{}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for _, child := range v {
+			children = append(children, child)
+		}
+	case []interface{}:
+		children = v
+	default:
+		return 0
+	}
+
+	depth := 0
+	for _, child := range children {
+		if d := valueDepth(child); d > depth {
+			depth = d
+		}
+	}
+
+	return depth + 1
+}
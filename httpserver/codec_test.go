@@ -0,0 +1,94 @@
+package httpserver
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestJSONCodecSetFailure(t *testing.T) {
+	payload := `{"a":1}`
+	if got, ok := (JSONCodec{}).Set(payload, "x", make(chan int)); ok || got != payload {
+		t.Fatalf("got %q %v, want the payload unchanged", got, ok)
+	}
+}
+
+func TestMsgPackCodecRoundTrip(t *testing.T) {
+	c := MsgPackCodec{}
+
+	payload, err := c.Encode(map[string]interface{}{"a": "1", "n": 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, ok := c.Set(payload, "__meta__", map[string]interface{}{
+		"etag":    "v1",
+		"max_age": 60,
+		"cookies": []interface{}{map[string]interface{}{"name": "s"}},
+	})
+	if !ok {
+		t.Fatal("set failed")
+	}
+	if _, ok := c.Set(payload, "__meta__", "again"); ok {
+		t.Fatal("set replaced a present key")
+	}
+
+	rest, meta, ok := c.Extract(payload, "__meta__")
+	if !ok {
+		t.Fatal("extract failed")
+	}
+	want := map[string]interface{}{
+		"etag":    "v1",
+		"max_age": float64(60),
+		"cookies": []interface{}{map[string]interface{}{"name": "s"}},
+	}
+	if !reflect.DeepEqual(meta, want) {
+		t.Fatalf("meta %#v, want %#v", meta, want)
+	}
+
+	if _, _, ok := c.Extract(rest, "__meta__"); ok {
+		t.Fatal("meta left in the payload")
+	}
+	m, ok := c.decode(rest)
+	if !ok || m["a"] != "1" || normalizeValue(m["n"]) != float64(2) {
+		t.Fatalf("rest %#v", m)
+	}
+
+	if _, ok := c.Set("not msgpack", "k", "v"); ok {
+		t.Fatal("set on an invalid payload")
+	}
+}
+
+func TestMsgPackCodecEngine(t *testing.T) {
+	c := MsgPackCodec{}
+
+	pkg := testPackage(t)
+	e := NewEngine(WithCodec(c), WithStaticPackage(pkg, "v1", funcTunnel(func(route string, req string) string {
+		m, ok := c.decode(req)
+		if !ok || m["a"] != "1" {
+			return "error://bad request"
+		}
+		if _, ok := m["__meta__"].(map[string]interface{}); !ok {
+			return "error://missing meta"
+		}
+
+		rsp, _ := c.Encode(map[string]interface{}{
+			"ok": true,
+			"__meta__": map[string]interface{}{
+				"cookies": []interface{}{map[string]interface{}{"name": "s", "value": "v", "max-age": 60}},
+			},
+		})
+		return rsp
+	})))
+
+	w := serve(e, http.MethodGet, "/api/"+pkg+"/v1/route?a=1", "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d %s", w.Code, w.Body.String())
+	}
+	if cookie := w.Header().Get("Set-Cookie"); !strings.Contains(cookie, "Max-Age=60") {
+		t.Fatalf("Set-Cookie %q", cookie)
+	}
+	if m, ok := c.decode(w.Body.String()); !ok || !reflect.DeepEqual(m, map[string]interface{}{"ok": true}) {
+		t.Fatalf("body %#v", m)
+	}
+}
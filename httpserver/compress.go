@@ -0,0 +1,101 @@
+package httpserver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	CodecBrotli = "br"
+	CodecGzip   = "gzip"
+)
+
+var compressors = map[string]func(io.Writer) io.WriteCloser{
+	CodecBrotli: func(w io.Writer) io.WriteCloser {
+		return brotli.NewWriter(w)
+	},
+	CodecGzip: func(w io.Writer) io.WriteCloser {
+		return gzip.NewWriter(w)
+	},
+}
+
+// negotiateEncoding picks the enabled codec with the highest quality value
+// in Accept-Encoding, ties are broken by the configured codec order
+func (e *Engine) negotiateEncoding(c *gin.Context) string {
+	if len(e.CompressionCodecs) == 0 {
+		return ""
+	}
+
+	qualities := map[string]float64{}
+	for _, part := range strings.Split(c.Request.Header.Get("Accept-Encoding"), ",") {
+		fields := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		if name == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64); err == nil {
+					q = v
+				}
+			}
+		}
+		qualities[name] = q
+	}
+
+	var (
+		best        string
+		bestQuality float64
+	)
+	for _, codec := range e.CompressionCodecs {
+		q, ok := qualities[codec]
+		if !ok {
+			q, ok = qualities["*"]
+		}
+		if ok && q > bestQuality {
+			best, bestQuality = codec, q
+		}
+	}
+
+	return best
+}
+
+// writeResponse writes the body compressed with the negotiated codec
+func (e *Engine) writeResponse(c *gin.Context, contentType string, body string) {
+	if contentType == "" {
+		contentType = "text/plain; charset=utf-8"
+	}
+
+	if len(e.CompressionCodecs) > 0 {
+		c.Header("Vary", "Accept-Encoding")
+	}
+
+	encoding := e.negotiateEncoding(c)
+	if encoding == "" {
+		c.Data(http.StatusOK, contentType, []byte(body))
+		return
+	}
+
+	var buf bytes.Buffer
+	w := compressors[encoding](&buf)
+	if _, err := io.WriteString(w, body); err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := w.Close(); err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.Header("Content-Encoding", encoding)
+	c.Data(http.StatusOK, contentType, buf.Bytes())
+}
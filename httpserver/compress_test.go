@@ -0,0 +1,67 @@
+package httpserver
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+func TestCompression(t *testing.T) {
+	pkg := testPackage(t)
+	e := NewEngine(
+		WithCompressionCodecs([]string{CodecBrotli, CodecGzip}),
+		WithStaticPackage(pkg, "v1", funcTunnel(func(route string, req string) string {
+			return `{"data":"` + strings.Repeat("x", 512) + `","__meta__":{"etag":"abc"}}`
+		})),
+	)
+	want := `{"data":"` + strings.Repeat("x", 512) + `"}`
+
+	for _, tc := range []struct {
+		acceptEncoding string
+		encoding       string
+	}{
+		{"gzip, br", CodecBrotli},
+		{"gzip, br;q=0.5", CodecGzip},
+		{"gzip", CodecGzip},
+		{"*", CodecBrotli},
+		{"identity", ""},
+		{"", ""},
+	} {
+		w := serve(e, http.MethodGet, "/api/"+pkg+"/v1/route", "", http.Header{"Accept-Encoding": {tc.acceptEncoding}})
+		if w.Code != http.StatusOK || w.Header().Get("Content-Encoding") != tc.encoding || w.Header().Get("Vary") != "Accept-Encoding" {
+			t.Fatalf("%q: got %d %v", tc.acceptEncoding, w.Code, w.Header())
+		}
+
+		var r io.Reader = w.Body
+		switch tc.encoding {
+		case CodecBrotli:
+			r = brotli.NewReader(r)
+		case CodecGzip:
+			gz, err := gzip.NewReader(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r = gz
+		}
+		data, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != want {
+			t.Fatalf("%q: got %s", tc.acceptEncoding, data)
+		}
+
+		// a compressed representation is only weakly equal to the tunnel's
+		etag := `"abc"`
+		if tc.encoding != "" {
+			etag = `W/"abc"`
+		}
+		if w.Header().Get("ETag") != etag {
+			t.Fatalf("%q: etag %s", tc.acceptEncoding, w.Header().Get("ETag"))
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package httpserver
+
+import (
+	"fmt"
+	"sort"
+)
+
+// EffectiveConfig returns the resolved configuration the engine runs with,
+// nothing is read from the environment so no deployment secrets leak
+func (e *Engine) EffectiveConfig() map[string]interface{} {
+	rateLimits := map[string]string{}
+	for packageName, limit := range e.RateLimits {
+		rateLimits[packageName] = fmt.Sprintf("%g", float64(limit))
+	}
+
+	staticResponses := make([]string, 0, len(e.StaticResponseMap))
+	for path := range e.StaticResponseMap {
+		staticResponses = append(staticResponses, path)
+	}
+	sort.Strings(staticResponses)
+
+	allowedPackages := make([]string, 0, len(e.AllowedPackages))
+	for name := range e.AllowedPackages {
+		allowedPackages = append(allowedPackages, name)
+	}
+	sort.Strings(allowedPackages)
+
+	fallbackPackage := ""
+	if e.FallbackPackage != nil {
+		fallbackPackage = fmt.Sprintf("%s@%s", e.FallbackPackage.Name, e.FallbackPackage.Commit)
+	}
+
+	return map[string]interface{}{
+		"release_mode":     e.ReleaseMode,
+		"debug":            !e.ReleaseMode,
+		"namespace":        e.Namespace,
+		"static_link_map":  e.StaticLinkMap,
+		"prefix_link_map":  e.PrefixLinkMap,
+		"header_links":     e.HeaderLinks,
+		"static_packages":  packageNames(e.StaticPackages),
+		"preload_packages": packageNames(e.PreloadPackages),
+		"preload_policy":   e.PreloadPolicy,
+		"static_responses": staticResponses,
+		"fallback_package": fallbackPackage,
+		"allowed_packages": allowedPackages,
+		"version_aliases":  e.VersionAliases,
+		"canaries":         e.Canaries,
+		"limits": map[string]interface{}{
+			"max_decompressed_size": e.MaxDecompressedSize,
+			"max_meta_depth":        e.MaxMetaDepth,
+			"max_header_count":      e.MaxHeaderCount,
+			"max_header_bytes":      e.MaxHeaderBytes,
+			"rate_limits":           rateLimits,
+			"default_rate_limit":    fmt.Sprintf("%g", float64(e.DefaultRateLimit)),
+			"body_limits":           e.BodyLimits,
+			"package_limits":        e.PackageLimits,
+			"bulkheads":             e.Bulkheads,
+			"bulkhead_timeout":      e.BulkheadTimeout.String(),
+		},
+		"features": map[string]interface{}{
+			"presign_redirect":          e.PresignSigner != nil,
+			"tracing":                   e.Tracer != nil,
+			"middlewares":               len(e.Middlewares),
+			"correlation_header":        e.CorrelationHeader,
+			"request_id_header":         e.RequestIDHeader,
+			"empty_query_policy":        e.EmptyQueryPolicy,
+			"close_tunnels_on_shutdown": e.CloseTunnelsOnShutdown,
+			"forward_headers":           e.ForwardHeaders,
+			"api_gateway_v2":            e.APIGatewayV2,
+			"after_response":            e.AfterResponseHook != nil,
+			"deep_health_check":         e.DeepHealthCheck,
+			"compression_codecs":        e.CompressionCodecs,
+			"signed_url_verification":   len(e.SignedURLKeys) > 0,
+			"signed_url_trusted_hops":   e.SignedURLTrustedHops,
+			"trailing_slash":            e.TrailingSlash,
+			"build_info":                e.BuildInfo,
+			"ready_path":                e.ReadyPath,
+			"response_transform":        e.ResponseTransform != nil,
+			"raw_response_transform":    e.RawResponseTransform != nil,
+			"request_validator":         e.RequestValidator != nil,
+			"request_transform":         e.RequestTransform != nil,
+			"route_params":              e.RouteParams,
+			"codec":                     fmt.Sprintf("%T", e.Codec),
+			"debug_prefix":              e.DebugPrefix,
+			"debug_header":              e.DebugHeaderKey,
+			"json_debug":                e.JSONDebug,
+			"debug_capture":             e.DebugCapture,
+			"panic_recovery":            e.PanicRecovery,
+			"routes_endpoint":           e.RoutesEndpoint,
+			"config_endpoint":           e.ConfigEndpoint,
+			"canary_header":             e.CanaryHeader,
+		},
+	}
+}
+
+func packageNames(packages []*Package) []string {
+	names := make([]string, 0, len(packages))
+	for _, p := range packages {
+		names = append(names, fmt.Sprintf("%s@%s", p.Name, p.Commit))
+	}
+	return names
+}
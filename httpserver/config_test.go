@@ -0,0 +1,79 @@
+package httpserver
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestConfigEndpoint(t *testing.T) {
+	if w := serve(NewEngine(), http.MethodGet, "/_/config", "", nil); w.Code != http.StatusNotFound {
+		t.Fatalf("without WithConfigEndpoint: got %d", w.Code)
+	}
+
+	e := NewEngine(WithConfigEndpoint(), WithStaticLink("/old", "/api/pkg/v1/new"), WithPrefixLink("/v1", "/api/pkg/v1"), WithHeaderLinkKey("X-Route", "/api/pkg/v1"))
+	w := serve(e, http.MethodGet, "/_/config", "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d", w.Code)
+	}
+
+	var config struct {
+		Debug         bool              `json:"debug"`
+		StaticLinkMap map[string]string `json:"static_link_map"`
+		PrefixLinkMap map[string]string `json:"prefix_link_map"`
+		HeaderLinks   []*HeaderLink     `json:"header_links"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &config); err != nil {
+		t.Fatal(err)
+	}
+	if !config.Debug || config.StaticLinkMap["/old"] != "/api/pkg/v1/new" || config.PrefixLinkMap["/v1"] != "/api/pkg/v1" {
+		t.Fatalf("config %s", w.Body.String())
+	}
+	if len(config.HeaderLinks) != 1 || config.HeaderLinks[0].Key != "X-Route" {
+		t.Fatalf("header links %s", w.Body.String())
+	}
+}
+
+func TestEffectiveConfig(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := NewEngine(
+		WithNamespace(""),
+		WithMaxMetaDepth(5),
+		WithBodyLimits(1024, 2048),
+		WithCompressionCodecs([]string{CodecGzip}),
+		WithPresignRedirect(func(key string) (string, error) { return "", nil }),
+		WithSignedURLVerification(map[string]*rsa.PublicKey{"K1": &key.PublicKey}),
+	)
+	config := e.EffectiveConfig()
+
+	limits := config["limits"].(map[string]interface{})
+	if limits["max_meta_depth"] != 5 || limits["body_limits"].(*BodyLimits).MaxResponseBytes != 2048 {
+		t.Fatalf("limits %v", limits)
+	}
+	features := config["features"].(map[string]interface{})
+	if features["presign_redirect"] != true || features["signed_url_verification"] != true || features["config_endpoint"] != false {
+		t.Fatalf("features %v", features)
+	}
+	if codecs := features["compression_codecs"].([]string); len(codecs) != 1 || codecs[0] != CodecGzip {
+		t.Fatalf("compression codecs %v", codecs)
+	}
+
+	// keys and functions are reported as enabled, never by value
+	data, err := json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), key.PublicKey.N.String()) || strings.Contains(string(data), "K1") {
+		t.Fatalf("config leaks the signing keys: %s", data)
+	}
+	if _, ok := config["env"]; ok {
+		t.Fatal("config reads the environment")
+	}
+}
@@ -1,18 +1,46 @@
 package httpserver
 
 import (
+	"net/http"
+	"strings"
+	"sync"
+
 	"github.com/gin-gonic/gin"
 )
 
 type Engine struct {
 	*Options
 	*gin.Engine
+	rateLimiter   *rateLimiter
+	bulkhead      *bulkhead
+	afterResponse *afterResponsePool
+	packages      sync.Map
 }
 
 func NewEngine(opts ...Option) *Engine {
+	options := NewOptions(opts...)
+
+	engine := gin.Default()
+	if !options.PanicRecovery {
+		// leave out gin's recovery so panics reach the caller
+		engine = gin.New()
+		engine.Use(gin.Logger())
+	}
+
 	e := &Engine{
-		Options: NewOptions(opts...),
-		Engine:  gin.Default(),
+		Options: options,
+		Engine:  engine,
+	}
+
+	e.rateLimiter = newRateLimiter(e.RateLimits, e.DefaultRateLimit)
+	e.bulkhead = newBulkhead(e.Bulkheads, e.BulkheadTimeout)
+	if e.AfterResponseHook != nil {
+		e.afterResponse = newAfterResponsePool(e.AfterResponseHook, e.AfterResponseWorkers, e.AfterResponseQueueSize, e.AfterResponseDrain, e.Logger)
+	}
+
+	e.Engine.HandleMethodNotAllowed = true
+	if e.TrailingSlash == TrailingSlashNone {
+		e.Engine.RedirectTrailingSlash = false
 	}
 
 	e.Engine.SetTrustedProxies(nil)
@@ -27,3 +55,48 @@ func NewEngine(opts ...Option) *Engine {
 
 	return e
 }
+
+func (e *Engine) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if rsp, ok := e.StaticResponseMap[r.URL.Path]; ok {
+		if rsp.ContentType != "" {
+			w.Header().Set("Content-Type", rsp.ContentType)
+		}
+		w.WriteHeader(rsp.Status)
+		if r.Method != http.MethodHead {
+			w.Write(rsp.Body)
+		}
+		return
+	}
+
+	if path := r.URL.Path; len(path) > 1 && strings.HasSuffix(path, "/") {
+		switch e.TrailingSlash {
+		case TrailingSlashRedirect:
+			u := *r.URL
+			u.Path = "/" + strings.Trim(path, "/")
+			u.RawPath = ""
+			code := http.StatusMovedPermanently
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				code = http.StatusPermanentRedirect
+			}
+			http.Redirect(w, r, u.String(), code)
+			return
+		case TrailingSlashRewrite:
+			r.URL.Path = "/" + strings.Trim(path, "/")
+			r.URL.RawPath = ""
+		}
+	}
+
+	e.Engine.ServeHTTP(w, r)
+}
+
+// Stop releases the resources held by the engine once the server
+// no longer serves requests
+func (e *Engine) Stop() {
+	if e.afterResponse != nil {
+		e.afterResponse.Close()
+	}
+
+	if e.CloseTunnelsOnShutdown {
+		e.CloseTunnels()
+	}
+}
@@ -0,0 +1,204 @@
+package httpserver
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestStaticResponse(t *testing.T) {
+	var invoked int32
+	pkg := testPackage(t)
+	e := NewEngine(
+		WithStaticPackage(pkg, "v1", funcTunnel(func(route string, req string) string {
+			atomic.AddInt32(&invoked, 1)
+			return "fallback"
+		})),
+		WithFallbackPackage(pkg, "v1"),
+		WithStaticResponse("/robots.txt", http.StatusOK, "text/plain", []byte("User-agent: *\nDisallow: /\n")),
+		WithStaticResponse("/api/"+pkg+"/v1/favicon.ico", http.StatusNoContent, "", nil),
+	)
+
+	w := serve(e, http.MethodGet, "/robots.txt", "", nil)
+	if w.Code != http.StatusOK || w.Body.String() != "User-agent: *\nDisallow: /\n" || w.Header().Get("Content-Type") != "text/plain" {
+		t.Fatalf("got %d %v %s", w.Code, w.Header(), w.Body.String())
+	}
+	if w := serve(e, http.MethodHead, "/robots.txt", "", nil); w.Code != http.StatusOK || w.Body.Len() != 0 {
+		t.Fatalf("head: got %d %s", w.Code, w.Body.String())
+	}
+	if w := serve(e, http.MethodGet, "/api/"+pkg+"/v1/favicon.ico", "", nil); w.Code != http.StatusNoContent {
+		t.Fatalf("favicon: got %d", w.Code)
+	}
+	if n := atomic.LoadInt32(&invoked); n != 0 {
+		t.Fatalf("package invoked %d times", n)
+	}
+}
+
+func TestRootHandler(t *testing.T) {
+	e := NewEngine(WithRootHandler(RootRedirect("/docs")))
+	if w := serve(e, http.MethodGet, "/", "", nil); w.Code != http.StatusFound || w.Header().Get("Location") != "/docs" {
+		t.Fatalf("redirect: got %d %v", w.Code, w.Header())
+	}
+	if w := serve(e, http.MethodGet, "/health-check", "", nil); w.Code != http.StatusOK || w.Body.String() != "OK" {
+		t.Fatalf("redirect health-check: got %d %s", w.Code, w.Body.String())
+	}
+
+	e = NewEngine(WithRootHandler(RootNotFound()))
+	if w := serve(e, http.MethodGet, "/", "", nil); w.Code != http.StatusNotFound {
+		t.Fatalf("not found: got %d", w.Code)
+	}
+	if w := serve(e, http.MethodGet, "/health-check", "", nil); w.Code != http.StatusOK || w.Body.String() != "OK" {
+		t.Fatalf("not found health-check: got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestNotFoundHandler(t *testing.T) {
+	e := NewEngine(WithNotFoundHandler(func(c *gin.Context) {
+		c.JSON(c.Writer.Status(), gin.H{"error": "not found", "path": c.Request.URL.Path})
+	}))
+
+	w := serve(e, http.MethodGet, "/missing", "", nil)
+	if w.Code != http.StatusNotFound || !strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+		t.Fatalf("got %d %v", w.Code, w.Header())
+	}
+	if w.Body.String() != `{"error":"not found","path":"/missing"}` {
+		t.Fatalf("got %s", w.Body.String())
+	}
+}
+
+func TestFallbackPackage(t *testing.T) {
+	pkg := testPackage(t)
+	fallback := funcTunnel(func(route string, req string) string { return "fallback " + route })
+
+	e := NewEngine(WithStaticPackage(pkg, "v1", fallback), WithFallbackPackage(pkg, "v1"))
+	w := serve(e, http.MethodGet, "/api/"+pkg+"-missing/v1/route", "", nil)
+	if w.Code != http.StatusOK || w.Body.String() != "fallback /"+pkg+"-missing/v1/route" {
+		t.Fatalf("fallback: got %d %s", w.Code, w.Body.String())
+	}
+
+	w = serve(NewEngine(), http.MethodGet, "/api/"+pkg+"-missing/v1/route", "", nil)
+	if w.Code == http.StatusOK || strings.HasPrefix(w.Body.String(), "fallback") {
+		t.Fatalf("no fallback: got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestPackageLimits(t *testing.T) {
+	pkg := testPackage(t)
+	// the tunnel responds with as many bytes as the route says
+	sized := funcTunnel(func(route string, req string) string {
+		n, _ := strconv.Atoi(strings.TrimPrefix(route, "/"))
+		return strings.Repeat("x", n)
+	})
+	small := "{}"
+	large := `{"data":"` + strings.Repeat("x", 2000) + `"}`
+
+	e := NewEngine(
+		WithBodyLimits(1000, 64),
+		WithStaticPackage(pkg, "v1", sized),
+		WithStaticPackage(pkg+"-loose", "v1", sized),
+		WithStaticPackage(pkg+"-strict", "v1", sized),
+		WithStaticPackage(pkg+"-strict", "v2", sized),
+		WithPackageLimits(pkg+"-loose", "", 4096, 256),
+		WithPackageLimits(pkg+"-strict", "v1", 16, 16),
+	)
+
+	for _, tc := range []struct {
+		target string
+		body   string
+		ok     bool
+	}{
+		{"/api/" + pkg + "/v1/32", small, true},
+		{"/api/" + pkg + "/v1/32", large, false},
+		{"/api/" + pkg + "/v1/128", small, false},
+		{"/api/" + pkg + "-loose/v1/128", large, true},
+		{"/api/" + pkg + "-loose/v1/512", small, false},
+		{"/api/" + pkg + "-strict/v1/8", small, false},
+		{"/api/" + pkg + "-strict/v1/32", small, false},
+		{"/api/" + pkg + "-strict/v2/32", small, true},
+	} {
+		w := serve(e, http.MethodPost, tc.target, tc.body, nil)
+		if ok := w.Code == http.StatusOK; ok != tc.ok {
+			t.Fatalf("%s with %d bytes: got %d %s", tc.target, len(tc.body), w.Code, w.Body.String())
+		}
+		if !tc.ok && !strings.Contains(w.Body.String(), "exceeds limit") {
+			t.Fatalf("%s with %d bytes: got %s", tc.target, len(tc.body), w.Body.String())
+		}
+	}
+}
+
+func TestTrailingSlash(t *testing.T) {
+	e := NewEngine(WithRedirectTrailingSlash(true))
+	if w := serve(e, http.MethodGet, "/health-check/?a=1", "", nil); w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != "/health-check?a=1" {
+		t.Fatalf("redirect get: got %d %v", w.Code, w.Header())
+	}
+	if w := serve(e, http.MethodPost, "/health-check/", "{}", nil); w.Code != http.StatusPermanentRedirect || w.Header().Get("Location") != "/health-check" {
+		t.Fatalf("redirect post: got %d %v", w.Code, w.Header())
+	}
+
+	e = NewEngine(WithRewriteTrailingSlash())
+	if w := serve(e, http.MethodPost, "/health-check/", "{}", nil); w.Code != http.StatusOK || w.Body.String() != "OK" {
+		t.Fatalf("rewrite: got %d %s", w.Code, w.Body.String())
+	}
+	if w := serve(e, http.MethodGet, "/", "", nil); w.Code != http.StatusOK {
+		t.Fatalf("rewrite root: got %d", w.Code)
+	}
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	allow := "GET, POST, PUT, DELETE, PATCH, HEAD, OPTIONS"
+
+	w := serve(NewEngine(), "TRACE", "/health-check", "", nil)
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != allow || w.Body.String() != "405 method not allowed" {
+		t.Fatalf("default: got %d %v %s", w.Code, w.Header(), w.Body.String())
+	}
+
+	e := NewEngine(WithMethodNotAllowedHandler(func(c *gin.Context) {
+		c.JSON(c.Writer.Status(), gin.H{"error": "method not allowed"})
+	}))
+	w = serve(e, "TRACE", "/health-check", "", nil)
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != allow || w.Body.String() != `{"error":"method not allowed"}` {
+		t.Fatalf("custom: got %d %v %s", w.Code, w.Header(), w.Body.String())
+	}
+}
+
+func TestAllowedPackages(t *testing.T) {
+	pkg := testPackage(t)
+	e := NewEngine(
+		WithAllowedPackages(pkg),
+		WithStaticPackage(pkg, "v1", funcTunnel(func(route string, req string) string { return "allowed" })),
+		WithStaticPackage(pkg+"-denied", "v1", funcTunnel(func(route string, req string) string { return "denied" })),
+		WithFallbackPackage(pkg, "v1"),
+	)
+
+	if w := serve(e, http.MethodGet, "/api/"+pkg+"/v1/route", "", nil); w.Code != http.StatusOK || w.Body.String() != "allowed" {
+		t.Fatalf("allowed: got %d %s", w.Code, w.Body.String())
+	}
+	// a package outside the list is rejected rather than falling back
+	for _, prefix := range []string{"/api/", "/wapi/"} {
+		w := serve(e, http.MethodGet, prefix+pkg+"-denied/v1/route", "", nil)
+		if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), ErrPackageNotAllowed.Error()) {
+			t.Fatalf("%s denied: got %d %s", prefix, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestMaxHeaders(t *testing.T) {
+	e := NewEngine(WithMaxHeaders(4, 64))
+
+	if w := serve(e, http.MethodGet, "/health-check", "", http.Header{"A": {"1"}, "B": {"2"}}); w.Code != http.StatusOK {
+		t.Fatalf("within limits: got %d", w.Code)
+	}
+	for name, header := range map[string]http.Header{
+		"count": {"A": {"1", "2", "3"}, "B": {"4", "5"}},
+		"bytes": {"Cookie": {strings.Repeat("x", 64)}},
+	} {
+		w := serve(e, http.MethodGet, "/health-check", "", header)
+		if w.Code != http.StatusRequestHeaderFieldsTooLarge || w.Body.String() != "431 request header fields too large" {
+			t.Fatalf("%s: got %d %s", name, w.Code, w.Body.String())
+		}
+	}
+}
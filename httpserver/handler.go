@@ -3,18 +3,25 @@ package httpserver
 import (
 	"bufio"
 	"bytes"
+	"compress/gzip"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"os"
+	"runtime/debug"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/aura-studio/dynamic"
 	"github.com/gin-gonic/gin"
 	"github.com/tidwall/gjson"
-	"github.com/tidwall/sjson"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
@@ -22,36 +29,86 @@ const (
 	PathContext         = "path"
 	RequestContext      = "request"
 	ResponseContext     = "response"
+	ResponseMetaContext = "response_meta"
 	MetaContext         = "meta"
 	WireRequestContext  = "wire_request"
 	WireResponseContext = "wire_response"
 	ErrorContext        = "error"
 	PanicContext        = "panic"
+	PanicStackContext   = "panic_stack"
 	DebugContext        = "debug"
 	StdoutContext       = "stdout"
 	StderrContext       = "stderr"
 	ProcessorContext    = "processor"
+	StreamContext       = "stream"
+	CorrelationContext  = "correlation_id"
+	RequestIDContext    = "request_id"
+	CanaryContext       = "canary"
 )
 
 const (
-	MetaRemoteAddr  = "remote_addr"
-	MetaXForwardFor = "x_forward_for"
+	MetaRemoteAddr    = "remote_addr"
+	MetaXForwardFor   = "x_forward_for"
+	MetaCorrelationID = "correlation_id"
+	MetaRequestID     = "request_id"
+	MetaHTTPMethod    = "http_method"
+	MetaProtocol      = "protocol"
+)
+
+const (
+	RspMetaPresign      = "presign"
+	RspMetaETag         = "etag"
+	RspMetaLastModified = "last_modified"
+	RspMetaContentType  = "content_type"
+	RspMetaWarning      = "warning"
+	RspMetaCookies      = "cookies"
+)
+
+// rspMetaTypes declares the expected type of known response meta fields,
+// fields with an unexpected type are dropped
+var rspMetaTypes = map[string]string{
+	RspMetaPresign:      "string",
+	RspMetaETag:         "string",
+	RspMetaLastModified: "string",
+	RspMetaContentType:  "string",
+	RspMetaWarning:      "string",
+	RspMetaCookies:      "json",
+}
+
+var (
+	ErrRequestTooLarge   = errors.New("request body too large")
+	ErrPackageNotAllowed = errors.New("package not allowed")
 )
 
 type Proccessor = func(*gin.Context, LocalHandler)
-type LocalHandler = func(string, string) (string, error)
+type LocalHandler = func(context.Context, string, string) (string, error)
 
 var methods = []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete, http.MethodPatch, http.MethodHead, http.MethodOptions}
 
 func (e *Engine) InstallHandlers() {
-	e.Use(e.HeaderLink, e.StaticLink, e.PrefixLink)
-
-	e.HandleAllMethods("/", e.OK)
-	e.HandleAllMethods("/health-check", e.OK)
+	e.Use(e.PrependMiddlewares...)
+	e.Use(e.HeaderLimit, e.HeaderLink, e.StaticLink, e.PrefixLink, e.AfterResponse, e.Correlation, e.RequestID, e.Tracing, e.SignedURL, e.DebugHeader)
+	e.Use(e.Middlewares...)
+
+	e.HandleAllMethods("/", e.RootHandler(e))
+	e.HandleAllMethods("/health-check", e.HealthCheck)
+	if e.ReadyPath != "" {
+		e.HandleAllMethods(e.ReadyPath, e.Ready)
+	}
+	if e.BuildInfo != nil {
+		e.HandleAllMethods("/version", e.Version)
+	}
+	if e.RoutesEndpoint {
+		e.HandleAllMethods("/_routes", e.ListRoutes)
+	}
 	e.HandleAllMethods("/api/*path", e.API)
-	e.HandleAllMethods("/_/api/*path", e.Debug, e.API)
+	e.HandleAllMethods(e.DebugPrefix+"/api/*path", e.Debug, e.API)
 	e.HandleAllMethods("/wapi/*path", e.WAPI)
-	e.HandleAllMethods("/_/wapi/*path", e.Debug, e.WAPI)
+	e.HandleAllMethods(e.DebugPrefix+"/wapi/*path", e.Debug, e.WAPI)
+	e.HandleAllMethods("/sse/*path", e.Stream, e.API)
+	if e.ConfigEndpoint {
+		e.HandleAllMethods(e.DebugPrefix+"/config", e.Config)
+	}
 	e.NoRoute(e.PageNotFound)
 	e.NoMethod(e.MethodNotAllowed)
 }
@@ -62,12 +119,43 @@ func (e *Engine) HandleAllMethods(relativePath string, handlers ...gin.HandlerFu
 	}
 }
 
+// HeaderLimit rejects requests carrying more header fields or bytes than
+// configured with 431
+func (e *Engine) HeaderLimit(c *gin.Context) {
+	if e.MaxHeaderCount <= 0 && e.MaxHeaderBytes <= 0 {
+		return
+	}
+
+	var count, size int
+	for k, vs := range c.Request.Header {
+		for _, v := range vs {
+			count++
+			size += len(k) + len(v)
+		}
+	}
+
+	if (e.MaxHeaderCount > 0 && count > e.MaxHeaderCount) || (e.MaxHeaderBytes > 0 && size > e.MaxHeaderBytes) {
+		c.String(http.StatusRequestHeaderFieldsTooLarge, "431 request header fields too large")
+		c.Abort()
+		return
+	}
+}
+
+// headerLinkKey marks a request already rewritten by a header link, so the
+// links configured later don't rewrite it again when it is handled again
+type headerLinkKey struct{}
+
 func (e *Engine) HeaderLink(c *gin.Context) {
-	for key, prefix := range e.HeaderLinkMap {
-		if headerLink, ok := c.Request.Header[key]; ok && len(headerLink) > 0 {
-			strs := []string{strings.TrimRight(prefix, "/"), strings.TrimLeft(headerLink[0], "/")}
+	if c.Request.Context().Value(headerLinkKey{}) != nil {
+		return
+	}
+
+	for _, l := range e.HeaderLinks {
+		if headerLink, ok := c.Request.Header[l.Key]; ok && len(headerLink) > 0 {
+			strs := []string{strings.TrimRight(l.Prefix, "/"), strings.TrimLeft(headerLink[0], "/")}
+			c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), headerLinkKey{}, true))
 			c.Request.URL.Path = strings.Join(strs, "/")
-			c.Request.Header.Del(key)
+			c.Request.Header.Del(l.Key)
 			e.HandleContext(c)
 			c.Abort()
 			return
@@ -84,15 +172,66 @@ func (e *Engine) StaticLink(c *gin.Context) {
 	}
 }
 
+// PrefixLink rewrites the longest prefix matching the path on a segment
+// boundary, so /v1 matches /v1 and /v1/x but not /v1beta
 func (e *Engine) PrefixLink(c *gin.Context) {
-	for oldPrefix, newPrefix := range e.PrefixLinkMap {
-		if strings.HasPrefix(c.Request.URL.Path, oldPrefix) {
-			c.Request.URL.Path = strings.Replace(c.Request.URL.Path, oldPrefix, newPrefix, 1)
-			e.HandleContext(c)
-			c.Abort()
-			return
+	path := c.Request.URL.Path
+
+	var oldPrefix string
+	for prefix := range e.PrefixLinkMap {
+		if matchPrefix(path, prefix) && len(prefix) > len(oldPrefix) {
+			oldPrefix = prefix
 		}
 	}
+	if oldPrefix == "" {
+		return
+	}
+
+	c.Request.URL.Path = joinPrefix(e.PrefixLinkMap[oldPrefix], strings.TrimPrefix(path, strings.TrimRight(oldPrefix, "/")))
+	e.HandleContext(c)
+	c.Abort()
+}
+
+func matchPrefix(path string, prefix string) bool {
+	if prefix == "/" {
+		return strings.HasPrefix(path, "/")
+	}
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+// joinPrefix appends the rest of a path, which is empty or starts with a
+// slash, to prefix without doubling the slash
+func joinPrefix(prefix string, rest string) string {
+	if rest == "" {
+		return prefix
+	}
+	return strings.TrimRight(prefix, "/") + rest
+}
+
+func (e *Engine) Correlation(c *gin.Context) {
+	if e.CorrelationHeader != "" {
+		e.propagateID(c, e.CorrelationHeader, CorrelationContext)
+	}
+}
+
+func (e *Engine) RequestID(c *gin.Context) {
+	if e.RequestIDHeader != "" {
+		e.propagateID(c, e.RequestIDHeader, RequestIDContext)
+	}
+}
+
+// propagateID reads the id from the request header or generates one,
+// stores it on the context and echoes it on the response header
+func (e *Engine) propagateID(c *gin.Context, header string, key string) {
+	id := c.Request.Header.Get(header)
+	if id == "" {
+		id = newID()
+		// keep the generated id stable when the context is handled again
+		c.Request.Header.Set(header, id)
+	}
+
+	c.Set(key, id)
+	c.Header(header, id)
 }
 
 func (e *Engine) OK(c *gin.Context) {
@@ -100,13 +239,45 @@ func (e *Engine) OK(c *gin.Context) {
 	c.Abort()
 }
 
+// Config dumps the effective configuration, it is only installed with
+// WithConfigEndpoint
+func (e *Engine) Config(c *gin.Context) {
+	c.JSON(http.StatusOK, e.EffectiveConfig())
+	c.Abort()
+}
+
 func (e *Engine) Debug(c *gin.Context) {
 	c.Set(DebugContext, true)
 }
 
+// DebugHeader turns on debug mode for any route when the configured
+// header holds a true value
+func (e *Engine) DebugHeader(c *gin.Context) {
+	if e.DebugHeaderKey == "" {
+		return
+	}
+
+	if debug, err := strconv.ParseBool(c.Request.Header.Get(e.DebugHeaderKey)); err == nil && debug {
+		c.Set(DebugContext, true)
+	}
+}
+
+// Stream makes API frame its response as server-sent events
+func (e *Engine) Stream(c *gin.Context) {
+	c.Set(StreamContext, true)
+}
+
 func (e *Engine) API(c *gin.Context) {
 	// path
 	c.Set(PathContext, c.Param("path"))
+	e.Canary(c)
+
+	// rate limit
+	if !e.allowRate(c.GetString(PathContext)) {
+		c.String(http.StatusTooManyRequests, "429 too many requests")
+		c.Abort()
+		return
+	}
 
 	// header
 	c.Set(HeaderContext, c.Request.Header)
@@ -114,17 +285,38 @@ func (e *Engine) API(c *gin.Context) {
 	// meta
 	c.Set(MetaContext, e.genMeta(c))
 
+	// decompress
+	if err := e.decompressReq(c); err != nil {
+		if errors.Is(err, ErrRequestTooLarge) {
+			c.String(http.StatusRequestEntityTooLarge, err.Error())
+		} else {
+			c.String(http.StatusBadRequest, err.Error())
+		}
+		c.Abort()
+		return
+	}
+
 	// request
-	if c.Request.Method == http.MethodGet {
-		c.Set(RequestContext, e.genGetReq(c))
-	} else if c.Request.Method == http.MethodPost {
-		c.Set(RequestContext, e.genPostReq(c))
-	} else {
-		c.Set(RequestContext, "")
+	req, err := e.genReq(c)
+	if err != nil {
+		e.Logger.Error("generate request failed", "path", c.Request.URL.Path, "correlation_id", c.GetString(CorrelationContext), "request_id", c.GetString(RequestIDContext), "error", err)
+		c.Set(ErrorContext, err)
+	}
+	c.Set(RequestContext, req)
+
+	// validate
+	if err == nil && e.RequestValidator != nil {
+		if err := e.RequestValidator(c.GetString(PathContext), req); err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			c.Abort()
+			return
+		}
 	}
 
 	// processor
-	if c.GetBool(DebugContext) {
+	if err != nil {
+		c.Set(ProcessorContext, e.skipProcessor)
+	} else if c.GetBool(DebugContext) {
 		c.Set(ProcessorContext, e.debugProcessor)
 	} else {
 		c.Set(ProcessorContext, e.safeProcessor)
@@ -139,9 +331,37 @@ func (e *Engine) API(c *gin.Context) {
 		return
 	}
 
+	// raw response transform
+	errV, _ := c.Get(ErrorContext)
+	panicV, _ := c.Get(PanicContext)
+	if e.RawResponseTransform != nil && errV == nil && panicV == nil {
+		rsp, err := e.RawResponseTransform(c.Request.Context(), c.GetString(PathContext), c.GetString(ResponseContext))
+		if err != nil {
+			c.String(http.StatusInternalServerError, err.Error())
+			c.Abort()
+			return
+		}
+		c.Set(ResponseContext, rsp)
+	}
+
+	// response meta
+	e.parseRspMeta(c)
+	e.setCookies(c)
+
 	// redirect
 	rsp := c.GetString(ResponseContext)
-	if strings.HasPrefix(rsp, "http://") || strings.HasPrefix(rsp, "https://") {
+	rspMeta := c.GetStringMap(ResponseMetaContext)
+	if key, ok := rspMeta[RspMetaPresign].(string); ok && e.PresignSigner != nil {
+		url, err := e.PresignSigner(key)
+		if err != nil {
+			c.String(http.StatusInternalServerError, err.Error())
+			c.Abort()
+			return
+		}
+		c.Redirect(http.StatusTemporaryRedirect, url)
+		c.Abort()
+		return
+	} else if strings.HasPrefix(rsp, "http://") || strings.HasPrefix(rsp, "https://") {
 		c.Redirect(http.StatusTemporaryRedirect, rsp)
 		c.Abort()
 		return
@@ -158,19 +378,43 @@ func (e *Engine) API(c *gin.Context) {
 
 	// response
 	if c.GetBool(DebugContext) {
-		c.String(http.StatusOK, e.formatDebug(c))
+		e.writeDebug(c, "api")
 		c.Abort()
 		return
 	} else if v, ok := c.Get(PanicContext); ok && v != nil {
-		c.String(http.StatusInternalServerError, v.(error).Error())
+		// the panic handler may have responded already
+		if !c.Writer.Written() {
+			c.String(http.StatusInternalServerError, v.(error).Error())
+		}
 		c.Abort()
 		return
 	} else if v, ok := c.Get(ErrorContext); ok && v != nil {
-		c.String(http.StatusInternalServerError, v.(error).Error())
+		c.String(errorStatus(v.(error)), v.(error).Error())
 		c.Abort()
 		return
 	} else {
-		c.String(http.StatusOK, c.GetString(ResponseContext))
+		if e.ResponseTransform != nil {
+			rsp, err := e.ResponseTransform(c.GetString(PathContext), c.GetString(ResponseContext))
+			if err != nil {
+				c.String(http.StatusInternalServerError, err.Error())
+				c.Abort()
+				return
+			}
+			c.Set(ResponseContext, rsp)
+		}
+		e.setWarningHeaders(c)
+		e.setConditionalHeaders(c)
+		if e.isNotModified(c) {
+			c.Status(http.StatusNotModified)
+			c.Abort()
+			return
+		}
+		contentType, _ := c.GetStringMap(ResponseMetaContext)[RspMetaContentType].(string)
+		if c.GetBool(StreamContext) || contentType == "text/event-stream" {
+			e.writeEventStream(c, c.GetString(ResponseContext))
+		} else {
+			e.writeResponse(c, contentType, c.GetString(ResponseContext))
+		}
 		c.Abort()
 		return
 	}
@@ -179,21 +423,50 @@ func (e *Engine) API(c *gin.Context) {
 func (e *Engine) WAPI(c *gin.Context) {
 	// path
 	c.Set(PathContext, c.Param("path"))
+	e.Canary(c)
+
+	// rate limit
+	if !e.allowRate(c.GetString(PathContext)) {
+		c.String(http.StatusTooManyRequests, "429 too many requests")
+		c.Abort()
+		return
+	}
 
 	// header
 	c.Set(HeaderContext, c.Request.Header)
 
+	// decompress
+	if err := e.decompressReq(c); err != nil {
+		if errors.Is(err, ErrRequestTooLarge) {
+			c.String(http.StatusRequestEntityTooLarge, err.Error())
+		} else {
+			c.String(http.StatusBadRequest, err.Error())
+		}
+		c.Abort()
+		return
+	}
+
 	// request
-	if c.Request.Method == http.MethodGet {
-		c.Set(RequestContext, e.genGetReq(c))
-	} else if c.Request.Method == http.MethodPost {
-		c.Set(RequestContext, e.genPostReq(c))
-	} else {
-		c.Set(RequestContext, "")
+	req, err := e.genReq(c)
+	if err != nil {
+		e.Logger.Error("generate request failed", "path", c.Request.URL.Path, "correlation_id", c.GetString(CorrelationContext), "request_id", c.GetString(RequestIDContext), "error", err)
+		c.Set(ErrorContext, err)
+	}
+	c.Set(RequestContext, req)
+
+	// validate
+	if err == nil && e.RequestValidator != nil {
+		if err := e.RequestValidator(c.GetString(PathContext), req); err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			c.Abort()
+			return
+		}
 	}
 
 	// processor
-	if c.GetBool(DebugContext) {
+	if err != nil {
+		c.Set(ProcessorContext, e.skipProcessor)
+	} else if c.GetBool(DebugContext) {
 		c.Set(ProcessorContext, e.debugWireProcessor)
 	} else {
 		c.Set(ProcessorContext, e.safeWireProcessor)
@@ -210,15 +483,18 @@ func (e *Engine) WAPI(c *gin.Context) {
 
 	// response
 	if c.GetBool(DebugContext) {
-		c.String(http.StatusOK, e.formatDebug(c))
+		e.writeDebug(c, "wapi")
 		c.Abort()
 		return
 	} else if v, ok := c.Get(PanicContext); ok && v != nil {
-		c.String(http.StatusInternalServerError, v.(error).Error())
+		// the panic handler may have responded already
+		if !c.Writer.Written() {
+			c.String(http.StatusInternalServerError, v.(error).Error())
+		}
 		c.Abort()
 		return
 	} else if v, ok := c.Get(ErrorContext); ok && v != nil {
-		c.String(http.StatusInternalServerError, v.(error).Error())
+		c.String(errorStatus(v.(error)), v.(error).Error())
 		c.Abort()
 		return
 	} else {
@@ -229,22 +505,79 @@ func (e *Engine) WAPI(c *gin.Context) {
 			return
 		}
 		c.Writer.WriteHeader(response.StatusCode)
-		for k, v := range response.Header {
-			c.Writer.Header().Set(k, v[0])
+		for k, vs := range response.Header {
+			for _, v := range vs {
+				c.Writer.Header().Add(k, v)
+			}
 		}
-		io.Copy(c.Writer, response.Body)
+		e.copyWireBody(c, response)
 		response.Body.Close()
 		c.Abort()
 		return
 	}
 }
 
+// copyWireBody streams the wire response body to the client, flushing
+// after every chunk so a large or chunked body is not buffered again
+func (e *Engine) copyWireBody(c *gin.Context, response *http.Response) {
+	for _, te := range response.TransferEncoding {
+		if te == "chunked" {
+			c.Writer.Header().Del("Content-Length")
+			c.Writer.Header().Set("Transfer-Encoding", "chunked")
+		}
+	}
+	if response.ContentLength < 0 {
+		c.Writer.Header().Del("Content-Length")
+	}
+
+	ctx := c.Request.Context()
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := response.Body.Read(buf)
+		if n > 0 {
+			if _, err := c.Writer.Write(buf[:n]); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		}
+		if err != nil {
+			if err != io.EOF {
+				e.Logger.Error("copy wire response failed", "path", c.Request.URL.Path, "request_id", c.GetString(RequestIDContext), "error", err)
+			}
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+	}
+}
+
 func (e *Engine) PageNotFound(c *gin.Context) {
+	if e.NotFoundHandler != nil {
+		c.Status(http.StatusNotFound)
+		e.NotFoundHandler(c)
+		c.Abort()
+		return
+	}
+
 	c.String(404, "404 page not found")
 	c.Abort()
 }
 
+// MethodNotAllowed lists the methods every route is installed for in the
+// Allow header
 func (e *Engine) MethodNotAllowed(c *gin.Context) {
+	c.Header("Allow", strings.Join(methods, ", "))
+	if e.MethodNotAllowedHandler != nil {
+		c.Status(http.StatusMethodNotAllowed)
+		e.MethodNotAllowedHandler(c)
+		c.Abort()
+		return
+	}
+
 	c.String(405, "405 method not allowed")
 	c.Abort()
 }
@@ -254,35 +587,117 @@ func (e *Engine) genMeta(c *gin.Context) map[string]interface{} {
 
 	meta[MetaXForwardFor] = c.Request.Header.Get("X-Forwarded-For")
 	meta[MetaRemoteAddr] = c.Request.RemoteAddr
+	if e.APIGatewayV2 {
+		// the lambda adapter forwards the HTTP API request context as json
+		requestContext := c.Request.Header.Get("X-Amzn-Request-Context")
+		if sourceIP := gjson.Get(requestContext, "http.sourceIp"); sourceIP.Exists() {
+			meta[MetaRemoteAddr] = sourceIP.String()
+		}
+		if method := gjson.Get(requestContext, "http.method"); method.Exists() {
+			meta[MetaHTTPMethod] = method.String()
+		}
+		if protocol := gjson.Get(requestContext, "http.protocol"); protocol.Exists() {
+			meta[MetaProtocol] = protocol.String()
+		}
+	}
+	if id := c.GetString(CorrelationContext); id != "" {
+		meta[MetaCorrelationID] = id
+	}
+	if id := c.GetString(RequestIDContext); id != "" {
+		meta[MetaRequestID] = id
+	}
+	for _, name := range e.ForwardHeaders {
+		if value := c.Request.Header.Get(name); value != "" {
+			meta[metaKey(name)] = value
+		}
+	}
 
 	return meta
 }
 
-func (e *Engine) genGetReq(c *gin.Context) string {
+// metaKey converts a header name to a meta key, e.g. Accept-Language
+// becomes accept_language
+func metaKey(header string) string {
+	return strings.ReplaceAll(strings.ToLower(header), "-", "_")
+}
+
+func (e *Engine) genReq(c *gin.Context) (string, error) {
+	if c.Request.Method == http.MethodGet {
+		return e.genGetReq(c)
+	} else if c.Request.Method == http.MethodPost {
+		return e.genPostReq(c)
+	}
+	return "", nil
+}
+
+func (e *Engine) genGetReq(c *gin.Context) (string, error) {
+	query := c.Request.URL.Query()
+	if len(query) == 0 {
+		switch e.EmptyQueryPolicy {
+		case EmptyString:
+			return "", nil
+		case Null:
+			return "null", nil
+		}
+	}
+
 	dataMap := map[string]interface{}{}
-	for k, v := range c.Request.URL.Query() {
+	for k, v := range query {
 		dataMap[k] = v[0]
 	}
-	data, err := json.Marshal(dataMap)
-	if err != nil {
-		log.Fatal(err)
-	}
 
-	return string(data)
+	return e.Codec.Encode(dataMap)
 }
 
-func (e *Engine) genPostReq(c *gin.Context) string {
+func (e *Engine) genPostReq(c *gin.Context) (string, error) {
 	data, err := io.ReadAll(c.Request.Body)
 	if err != nil {
-		log.Fatal(err)
+		return "", err
 	}
 	defer c.Request.Body.Close()
 
 	c.Request.Body = io.NopCloser(bytes.NewBuffer(data))
 
-	return string(data)
+	return string(data), nil
 }
 
+func (e *Engine) decompressReq(c *gin.Context) error {
+	if !strings.EqualFold(c.Request.Header.Get("Content-Encoding"), "gzip") {
+		return nil
+	}
+
+	reader, err := gzip.NewReader(c.Request.Body)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	var body io.Reader = reader
+	if e.MaxDecompressedSize > 0 {
+		// read one extra byte to detect bodies that decompress beyond the limit
+		body = io.LimitReader(reader, e.MaxDecompressedSize+1)
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	if e.MaxDecompressedSize > 0 && int64(len(data)) > e.MaxDecompressedSize {
+		return fmt.Errorf("%w: decompressed size exceeds %d bytes", ErrRequestTooLarge, e.MaxDecompressedSize)
+	}
+	c.Request.Body.Close()
+
+	c.Request.Body = io.NopCloser(bytes.NewBuffer(data))
+	c.Request.ContentLength = int64(len(data))
+	c.Request.Header.Del("Content-Encoding")
+	c.Request.Header.Del("Content-Length")
+
+	return nil
+}
+
+// skipProcessor leaves the context untouched so that an error raised
+// while assembling the request is responded as is
+func (e *Engine) skipProcessor(c *gin.Context, f LocalHandler) {}
+
 func (e *Engine) doWireProcessor(c *gin.Context, f LocalHandler) {
 	var (
 		wireReq string
@@ -306,11 +721,23 @@ func (e *Engine) doWireProcessor(c *gin.Context, f LocalHandler) {
 	}
 	wireReq = buf.String()
 
-	wireRsp, err = e.handle(path, wireReq)
+	if e.RequestTransform != nil {
+		if wireReq, err = e.RequestTransform(c.Request.Context(), path, wireReq); err != nil {
+			return
+		}
+	}
+
+	wireRsp, err = f(c.Request.Context(), path, wireReq)
 	if err != nil {
 		return
 	}
 
+	// the body is only read for the debug output, WAPI streams it from the
+	// wire response otherwise
+	if !c.GetBool(DebugContext) {
+		return
+	}
+
 	response, err := http.ReadResponse(bufio.NewReader(bytes.NewBufferString(wireRsp)), c.Request)
 	if response != nil {
 		defer response.Body.Close()
@@ -329,13 +756,13 @@ func (e *Engine) doWireProcessor(c *gin.Context, f LocalHandler) {
 }
 
 func (e *Engine) safeWireProcessor(c *gin.Context, f LocalHandler) {
-	c.Set(PanicContext, e.doSafe(func() {
+	c.Set(PanicContext, e.doSafe(c, func() {
 		e.doWireProcessor(c, f)
 	}))
 }
 
 func (e *Engine) debugWireProcessor(c *gin.Context, f LocalHandler) {
-	stdout, stderr, panicErr := e.doDebug(func() {
+	stdout, stderr, panicErr := e.doDebug(c, func() {
 		e.doWireProcessor(c, f)
 	})
 	c.Set(StdoutContext, stdout)
@@ -347,22 +774,242 @@ func (e *Engine) doProcessor(c *gin.Context, f LocalHandler) {
 	path := c.GetString(PathContext)
 	req := c.GetString(RequestContext)
 	meta := c.GetStringMap(MetaContext)
-	if gjson.ValidBytes([]byte(req)) && !gjson.Get(req, "__meta__").Exists() {
-		req, _ = sjson.Set(req, "__meta__", meta)
+	if r, ok := e.Codec.Set(req, "__meta__", meta); ok {
+		req = r
+	}
+	if params, ok := e.routeParams(path); ok {
+		if r, ok := e.Codec.Set(req, e.RouteParamsKey, params); ok {
+			req = r
+		}
+	}
+	if e.RequestTransform != nil {
+		var err error
+		if req, err = e.RequestTransform(c.Request.Context(), path, req); err != nil {
+			c.Set(ErrorContext, err)
+			return
+		}
 	}
-	rsp, err := f(path, req)
+	rsp, err := f(c.Request.Context(), path, req)
 	c.Set(ResponseContext, rsp)
 	c.Set(ErrorContext, err)
 }
 
+func (e *Engine) parseRspMeta(c *gin.Context) {
+	rsp, rspMeta, ok := e.Codec.Extract(c.GetString(ResponseContext), "__meta__")
+	if !ok {
+		return
+	}
+	c.Set(ResponseContext, rsp)
+
+	if depth := valueDepth(rspMeta); depth > e.MaxMetaDepth {
+		e.Logger.Warn("response meta ignored", "depth", depth, "max_depth", e.MaxMetaDepth)
+		return
+	}
+
+	meta := map[string]interface{}{}
+	for key, value := range rspMeta {
+		if t, ok := rspMetaTypes[key]; ok && t != valueType(value) {
+			e.Logger.Warn("response meta ignored", "key", key, "type", valueType(value))
+			continue
+		}
+		meta[key] = value
+	}
+	c.Set(ResponseMetaContext, meta)
+}
+
+// writeEventStream frames each line of the response as a server-sent event
+// and flushes it to the client, stopping early once the client disconnects.
+// Tunnels return the whole response at once, so this only provides the
+// framing, nothing is delivered before the tunnel returns.
+func (e *Engine) writeEventStream(c *gin.Context, rsp string) {
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Status(http.StatusOK)
+
+	ctx := c.Request.Context()
+	for _, line := range strings.Split(rsp, "\n") {
+		if line == "" {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		if _, err := fmt.Fprintf(c.Writer, "data: %s\n\n", line); err != nil {
+			return
+		}
+		c.Writer.Flush()
+	}
+}
+
+// setCookies sets the cookies listed in the response meta, invalid
+// entries are skipped
+func (e *Engine) setCookies(c *gin.Context) {
+	cookies, ok := c.GetStringMap(ResponseMetaContext)[RspMetaCookies].([]interface{})
+	if !ok {
+		return
+	}
+
+	for _, v := range cookies {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			e.Logger.Warn("response meta cookie ignored", "cookie", v)
+			continue
+		}
+
+		cookie, err := parseCookie(m)
+		if err != nil {
+			e.Logger.Warn("response meta cookie ignored", "cookie", m, "error", err)
+			continue
+		}
+		http.SetCookie(c.Writer, cookie)
+	}
+}
+
+// cookieAttributes lists the keys of a response meta cookie, an entry
+// with any other key is invalid
+var cookieAttributes = map[string]bool{
+	"name":     true,
+	"value":    true,
+	"path":     true,
+	"domain":   true,
+	"max-age":  true,
+	"httpOnly": true,
+	"secure":   true,
+	"sameSite": true,
+}
+
+func parseCookie(m map[string]interface{}) (*http.Cookie, error) {
+	for key := range m {
+		if !cookieAttributes[key] {
+			return nil, fmt.Errorf("unknown attribute %s", key)
+		}
+	}
+
+	cookie := &http.Cookie{}
+	cookie.Name, _ = m["name"].(string)
+	cookie.Value, _ = m["value"].(string)
+	cookie.Path, _ = m["path"].(string)
+	cookie.Domain, _ = m["domain"].(string)
+	cookie.HttpOnly, _ = m["httpOnly"].(bool)
+	cookie.Secure, _ = m["secure"].(bool)
+
+	if v, ok := m["max-age"]; ok {
+		maxAge, ok := v.(float64)
+		if !ok {
+			return nil, fmt.Errorf("invalid max-age %v", v)
+		}
+		cookie.MaxAge = int(maxAge)
+	}
+
+	if v, ok := m["sameSite"]; ok {
+		sameSite, _ := v.(string)
+		switch strings.ToLower(sameSite) {
+		case "lax":
+			cookie.SameSite = http.SameSiteLaxMode
+		case "strict":
+			cookie.SameSite = http.SameSiteStrictMode
+		case "none":
+			cookie.SameSite = http.SameSiteNoneMode
+		default:
+			return nil, fmt.Errorf("invalid sameSite %v", v)
+		}
+	}
+
+	if err := cookie.Valid(); err != nil {
+		return nil, err
+	}
+
+	return cookie, nil
+}
+
+// setWarningHeaders marks a degraded response with a miscellaneous
+// Warning header and X-Partial while keeping the 200 status
+func (e *Engine) setWarningHeaders(c *gin.Context) {
+	if warning, ok := c.GetStringMap(ResponseMetaContext)[RspMetaWarning].(string); ok && warning != "" {
+		c.Header("Warning", fmt.Sprintf("199 - %s", strconv.Quote(warning)))
+		c.Header("X-Partial", "true")
+	}
+}
+
+func (e *Engine) setConditionalHeaders(c *gin.Context) {
+	rspMeta := c.GetStringMap(ResponseMetaContext)
+
+	if etag, ok := rspMeta[RspMetaETag].(string); ok && etag != "" {
+		if !strings.HasSuffix(etag, `"`) {
+			etag = strconv.Quote(etag)
+		}
+		// compressed representations are not byte identical to the tagged one
+		if e.negotiateEncoding(c) != "" && !strings.HasPrefix(etag, "W/") {
+			etag = "W/" + etag
+		}
+		c.Header("ETag", etag)
+	}
+
+	if lastModified, ok := rspMeta[RspMetaLastModified].(string); ok {
+		if t, err := parseHTTPTime(lastModified); err == nil {
+			c.Header("Last-Modified", t.UTC().Format(http.TimeFormat))
+		}
+	}
+}
+
+// isNotModified evaluates the conditional request headers against the
+// ETag and Last-Modified response headers, If-None-Match takes precedence
+// over If-Modified-Since as defined by RFC 7232
+func (e *Engine) isNotModified(c *gin.Context) bool {
+	if c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead {
+		return false
+	}
+
+	etag := c.Writer.Header().Get("ETag")
+	if ifNoneMatch := c.Request.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		if etag == "" {
+			return false
+		}
+		for _, v := range strings.Split(ifNoneMatch, ",") {
+			v = strings.TrimSpace(v)
+			if v == "*" || strings.TrimPrefix(v, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+
+	lastModified := c.Writer.Header().Get("Last-Modified")
+	if ifModifiedSince := c.Request.Header.Get("If-Modified-Since"); ifModifiedSince != "" && lastModified != "" {
+		modifiedTime, err := http.ParseTime(lastModified)
+		if err != nil {
+			return false
+		}
+		sinceTime, err := http.ParseTime(ifModifiedSince)
+		if err != nil {
+			return false
+		}
+		return !modifiedTime.After(sinceTime)
+	}
+
+	return false
+}
+
+func parseHTTPTime(s string) (time.Time, error) {
+	if t, err := http.ParseTime(s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
 func (e *Engine) safeProcessor(c *gin.Context, f LocalHandler) {
-	c.Set(PanicContext, e.doSafe(func() {
+	c.Set(PanicContext, e.doSafe(c, func() {
 		e.doProcessor(c, f)
 	}))
 }
 
 func (e *Engine) debugProcessor(c *gin.Context, f LocalHandler) {
-	stdout, stderr, panicErr := e.doDebug(func() {
+	stdout, stderr, panicErr := e.doDebug(c, func() {
 		e.doProcessor(c, f)
 	})
 	c.Set(StdoutContext, stdout)
@@ -370,18 +1017,199 @@ func (e *Engine) debugProcessor(c *gin.Context, f LocalHandler) {
 	c.Set(PanicContext, panicErr)
 }
 
-func (e *Engine) handle(path string, req string) (string, error) {
-	strs := strings.Split(strings.Trim(path, "/"), "/")
-	packageName := strs[0]
-	commit := strs[1]
+func (e *Engine) handle(ctx context.Context, path string, req string) (string, error) {
+	t, err := e.resolve(path)
+	if err != nil && !errors.Is(err, ErrPackageNotAllowed) {
+		t, err = e.resolveFallback(path, err)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return e.invoke(ctx, t, req)
+}
+
+// allowRate applies the rate limit of the package in path, a package only
+// falls under the default limit once it is allowed and resolves
+func (e *Engine) allowRate(path string) bool {
+	packageName, _, _, err := parsePath(path)
+	if err != nil {
+		return true
+	}
+
+	return e.rateLimiter.Allow(packageName, func() bool {
+		_, err := e.resolve(path)
+		return err == nil
+	})
+}
+
+// errorStatus maps a handling error to its response status
+func errorStatus(err error) int {
+	if errors.Is(err, ErrBulkheadFull) {
+		return http.StatusServiceUnavailable
+	}
+	if errors.Is(err, ErrPackageNotAllowed) {
+		return http.StatusForbidden
+	}
+	return http.StatusInternalServerError
+}
+
+// target is a tunnel resolved from a request path
+type target struct {
+	Package string
+	Commit  string
+	Route   string
+	Tunnel  dynamic.Tunnel
+}
+
+func (e *Engine) resolve(path string) (*target, error) {
+	packageName, commit, route, err := parsePath(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(e.AllowedPackages) > 0 && !e.AllowedPackages[packageName] {
+		return nil, fmt.Errorf("package %s: %w", packageName, ErrPackageNotAllowed)
+	}
+	commit = e.resolveAlias(packageName, commit)
 
 	tunnel, err := dynamic.GetPackage(packageName, commit)
+	if err != nil {
+		return nil, err
+	}
+	if tunnel == nil {
+		return nil, fmt.Errorf("package %s@%s not found", packageName, commit)
+	}
+	e.addPackage(packageName, commit)
+
+	return &target{
+		Package: packageName,
+		Commit:  commit,
+		Route:   route,
+		Tunnel:  tunnel,
+	}, nil
+}
+
+// resolveFallback targets the fallback package with the full path as route,
+// the fallback is resolved directly so a missing fallback can't loop
+func (e *Engine) resolveFallback(path string, err error) (*target, error) {
+	if e.FallbackPackage == nil {
+		return nil, err
+	}
+
+	fallback, fallbackErr := dynamic.GetPackage(e.FallbackPackage.Name, e.FallbackPackage.Commit)
+	if fallbackErr != nil || fallback == nil {
+		return nil, err
+	}
+
+	return &target{
+		Package: e.FallbackPackage.Name,
+		Commit:  e.FallbackPackage.Commit,
+		Route:   path,
+		Tunnel:  fallback,
+	}, nil
+}
+
+// invoke calls the tunnel of the target, under a child span of the request
+// span when tracing is enabled
+func (e *Engine) invoke(ctx context.Context, t *target, req string) (rsp string, err error) {
+	if e.Tracer != nil {
+		_, span := e.Tracer.Start(ctx, "invoke", trace.WithAttributes(
+			attribute.String("lambda.package", t.Package),
+			attribute.String("lambda.version", t.Commit),
+			attribute.String("lambda.route", t.Route),
+		))
+		defer func() {
+			if err != nil {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+			}
+			span.End()
+		}()
+	}
+
+	limits := e.packageLimits(t.Package, t.Commit)
+	if limits.MaxRequestBytes > 0 && int64(len(req)) > limits.MaxRequestBytes {
+		return "", fmt.Errorf("request to package %s@%s is %d bytes, exceeds limit of %d bytes", t.Package, t.Commit, len(req), limits.MaxRequestBytes)
+	}
+
+	release, err := e.bulkhead.acquire(t.Package)
 	if err != nil {
 		return "", err
 	}
+	defer release()
+
+	output, _ := ctx.Value(debugOutputKey{}).(*debugOutput)
+	if tunnel, ok := t.Tunnel.(DebugTunnel); ok && output != nil {
+		rsp = tunnel.InvokeDebug(t.Route, req, output.stdout, output.stderr)
+	} else {
+		rsp = t.Tunnel.Invoke(t.Route, req)
+	}
+	if limits.MaxResponseBytes > 0 && int64(len(rsp)) > limits.MaxResponseBytes {
+		return "", fmt.Errorf("response from package %s@%s is %d bytes, exceeds limit of %d bytes", t.Package, t.Commit, len(rsp), limits.MaxResponseBytes)
+	}
+
+	return rsp, nil
+}
+
+// packageLimits looks up the limits of the package version, then of the
+// package as a whole, then the global limits
+func (e *Engine) packageLimits(packageName string, commit string) *BodyLimits {
+	if limits, ok := e.PackageLimits[packageName+"@"+commit]; ok {
+		return limits
+	}
+	if limits, ok := e.PackageLimits[packageName+"@"]; ok {
+		return limits
+	}
+	return e.BodyLimits
+}
+
+func parsePath(path string) (packageName string, commit string, route string, err error) {
+	strs := strings.Split(strings.Trim(path, "/"), "/")
+	if len(strs) < 2 {
+		return "", "", "", fmt.Errorf("invalid path %s", path)
+	}
 
-	route := fmt.Sprintf("/%s", strings.Join(strs[2:], "/"))
-	return tunnel.Invoke(route, req), nil
+	return strs[0], strs[1], fmt.Sprintf("/%s", strings.Join(strs[2:], "/")), nil
+}
+
+func (e *Engine) writeDebug(c *gin.Context, mode string) {
+	if e.JSONDebug {
+		c.Data(http.StatusOK, "application/json; charset=utf-8", e.formatJSONDebug(c, mode))
+		return
+	}
+
+	c.String(http.StatusOK, e.formatDebug(c))
+}
+
+// formatJSONDebug formats the debug output as a JSON object, a panic is
+// reported as the error
+func (e *Engine) formatJSONDebug(c *gin.Context, mode string) []byte {
+	var param string
+	if _, _, route, err := parsePath(c.GetString(PathContext)); err == nil {
+		param = route
+	}
+
+	var errStr string
+	if v, ok := c.Get(ErrorContext); ok && v != nil {
+		errStr = v.(error).Error()
+	} else if v, ok := c.Get(PanicContext); ok && v != nil {
+		errStr = v.(error).Error()
+	}
+
+	data, _ := json.Marshal(map[string]string{
+		"mode":        mode,
+		"raw_path":    c.Request.URL.Path,
+		"path":        c.GetString(PathContext),
+		"canary":      c.GetString(CanaryContext),
+		"param":       param,
+		"request":     c.GetString(RequestContext),
+		"response":    c.GetString(ResponseContext),
+		"error":       errStr,
+		"panic_stack": c.GetString(PanicStackContext),
+		"stdout":      c.GetString(StdoutContext),
+		"stderr":      c.GetString(StderrContext),
+	})
+	return data
 }
 
 func (e *Engine) formatDebug(c *gin.Context) string {
@@ -398,6 +1226,9 @@ func (e *Engine) formatDebug(c *gin.Context) string {
 	buf.WriteString(`Path: `)
 	buf.WriteString(c.GetString(PathContext))
 	buf.WriteString("\n")
+	buf.WriteString(`Canary: `)
+	buf.WriteString(c.GetString(CanaryContext))
+	buf.WriteString("\n")
 	buf.WriteString(`Header: `)
 	headerBytes, _ := json.Marshal(c.GetString(HeaderContext))
 	buf.WriteString(string(headerBytes))
@@ -422,6 +1253,9 @@ func (e *Engine) formatDebug(c *gin.Context) string {
 		buf.WriteString(v.(error).Error())
 	}
 	buf.WriteString("\n")
+	buf.WriteString(`Panic Stack: `)
+	buf.WriteString(c.GetString(PanicStackContext))
+	buf.WriteString("\n")
 	buf.WriteString(`Request: `)
 	buf.WriteString(c.GetString(RequestContext))
 	buf.WriteString("\n")
@@ -437,10 +1271,19 @@ func (e *Engine) formatDebug(c *gin.Context) string {
 	return buf.String()
 }
 
-func (e *Engine) doSafe(f func()) (err error) {
+func (e *Engine) doSafe(c *gin.Context, f func()) (err error) {
+	if !e.PanicRecovery {
+		f()
+		return nil
+	}
+
 	defer func() {
 		if v := recover(); v != nil {
 			err = fmt.Errorf("panic: %v", v)
+			c.Set(PanicStackContext, string(debug.Stack()))
+			if e.PanicHandler != nil {
+				e.PanicHandler(c, v)
+			}
 		}
 	}()
 
@@ -449,79 +1292,53 @@ func (e *Engine) doSafe(f func()) (err error) {
 	return nil
 }
 
-func (e *Engine) doDebug(f func()) (stdout string, stderr string, err error) {
-	defer func() {
-		if v := recover(); v != nil {
-			err = fmt.Errorf("panic: %v", v)
-		}
-	}()
+// DebugTunnel is implemented by tunnels which print through the writers
+// they are given, debug mode captures their output per request. Tunnels
+// printing to os.Stdout and os.Stderr are not captured, the process wide
+// files are never swapped.
+type DebugTunnel interface {
+	InvokeDebug(route string, req string, stdout io.Writer, stderr io.Writer) string
+}
 
-	// keep backup of the real file
-	originStdout := os.Stdout
-	originStderr := os.Stderr
+// debugOutputKey carries the capture of a debug request on the request
+// context down to invoke
+type debugOutputKey struct{}
 
-	// Restore original file
-	defer func() {
-		os.Stdout = originStdout
-		os.Stderr = originStderr
-	}()
+type debugOutput struct {
+	stdout io.Writer
+	stderr io.Writer
+}
 
-	// Create pipe to create reader & writer
-	stdoutPipeReader, stdoutPipeWriter, err := os.Pipe()
-	if err != nil {
-		panic(err)
-	}
-	defer stdoutPipeWriter.Close()
-	stderrPipeReader, stderrPipeWriter, err := os.Pipe()
-	if err != nil {
-		panic(err)
+func (e *Engine) doDebug(c *gin.Context, f func()) (stdout string, stderr string, err error) {
+	if e.PanicRecovery {
+		defer func() {
+			if v := recover(); v != nil {
+				err = fmt.Errorf("panic: %v", v)
+				c.Set(PanicStackContext, string(debug.Stack()))
+			}
+		}()
 	}
-	defer stderrPipeWriter.Close()
-
-	// Connect file to writer side of pipe
-	os.Stdout = stdoutPipeWriter
-	os.Stderr = stderrPipeWriter
-
-	// Create MultiWriter to write to buffer and file at the same time
-	var (
-		stdoutBuf bytes.Buffer
-		stderrBuf bytes.Buffer
-	)
-	stdoutMultiWriter := io.MultiWriter(&stdoutBuf, originStdout)
-	stderrMultiWriter := io.MultiWriter(&stderrBuf, originStderr)
 
-	// copy the output in a separate goroutine so printing can't block indefinitely
-	stdoutErrCh := make(chan error, 1)
-	go func() {
-		if _, err := io.Copy(stdoutMultiWriter, stdoutPipeReader); err != nil {
-			stdoutErrCh <- err
-		}
-		stdoutErrCh <- nil
-	}()
-	go func() {
-		if _, err := io.Copy(stderrMultiWriter, stderrPipeReader); err != nil {
-			stdoutErrCh <- err
-		}
-		stdoutErrCh <- nil
-	}()
-
-	f()
-
-	if err := stdoutPipeWriter.Close(); err != nil {
-		panic(err)
+	if !e.DebugCapture {
+		f()
+		return "", "", nil
 	}
 
-	if err := stderrPipeWriter.Close(); err != nil {
-		panic(err)
+	// the output still reaches the real files, as it would without debug
+	var stdoutBuf, stderrBuf bytes.Buffer
+	output := &debugOutput{
+		stdout: io.MultiWriter(&stdoutBuf, os.Stdout),
+		stderr: io.MultiWriter(&stderrBuf, os.Stderr),
 	}
 
-	if err := <-stdoutErrCh; err != nil {
-		panic(err)
-	}
+	// a path:// response handles the context again, possibly without debug
+	r := c.Request
+	c.Request = r.WithContext(context.WithValue(r.Context(), debugOutputKey{}, output))
+	defer func() {
+		c.Request = r
+	}()
 
-	if err := <-stdoutErrCh; err != nil {
-		panic(err)
-	}
+	f()
 
 	return stdoutBuf.String(), stderrBuf.String(), nil
 }
@@ -0,0 +1,930 @@
+package httpserver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// funcTunnel is a tunnel invoking a function
+type funcTunnel func(route string, req string) string
+
+func (funcTunnel) Init()                                    {}
+func (funcTunnel) Close()                                   {}
+func (f funcTunnel) Invoke(route string, req string) string { return f(route, req) }
+
+// testPackage names a package after the test, the dynamic registry is
+// process wide so every test registers packages of its own
+func testPackage(t *testing.T) string {
+	return strings.ToLower(strings.ReplaceAll(t.Name(), "/", "-"))
+}
+
+func serve(e *Engine, method string, target string, body string, header http.Header) *httptest.ResponseRecorder {
+	var r *http.Request
+	if body == "" {
+		r = httptest.NewRequest(method, target, nil)
+	} else {
+		r = httptest.NewRequest(method, target, strings.NewReader(body))
+	}
+	for k, v := range header {
+		r.Header[k] = v
+	}
+	w := httptest.NewRecorder()
+	e.ServeHTTP(w, r)
+	return w
+}
+
+// printTunnel prints the route to the debug writers it is given
+type printTunnel struct {
+	invoke func(route string)
+}
+
+func (printTunnel) Init()  {}
+func (printTunnel) Close() {}
+
+func (p printTunnel) Invoke(route string, req string) string {
+	if p.invoke != nil {
+		p.invoke(route)
+	}
+	return route
+}
+
+func (p printTunnel) InvokeDebug(route string, req string, stdout io.Writer, stderr io.Writer) string {
+	fmt.Fprintf(stdout, "out %s\n", route)
+	fmt.Fprintf(stderr, "err %s\n", route)
+	return p.Invoke(route, req)
+}
+
+func TestDebugCaptureConcurrent(t *testing.T) {
+	pkg := testPackage(t)
+	e := NewEngine(WithStaticPackage(pkg, "v1", printTunnel{}), WithJSONDebug())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			w := serve(e, http.MethodGet, fmt.Sprintf("/_/api/%s/v1/%d", pkg, i), "", nil)
+			var output map[string]string
+			if err := json.Unmarshal(w.Body.Bytes(), &output); err != nil {
+				t.Error(err)
+				return
+			}
+			if want := fmt.Sprintf("out /%d\n", i); output["stdout"] != want {
+				t.Errorf("request %d: stdout %q, want %q", i, output["stdout"], want)
+			}
+			if want := fmt.Sprintf("err /%d\n", i); output["stderr"] != want {
+				t.Errorf("request %d: stderr %q, want %q", i, output["stderr"], want)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestDebugCaptureDoesNotBlock(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	pkg := testPackage(t)
+	e := NewEngine(WithStaticPackage(pkg, "v1", printTunnel{invoke: func(route string) {
+		if route == "/slow" {
+			close(started)
+			<-release
+		}
+	}}))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		serve(e, http.MethodGet, "/_/api/"+pkg+"/v1/slow", "", nil)
+	}()
+	<-started
+
+	fast := make(chan int)
+	go func() {
+		fast <- serve(e, http.MethodGet, "/api/"+pkg+"/v1/fast", "", nil).Code
+	}()
+	select {
+	case code := <-fast:
+		if code != http.StatusOK {
+			t.Errorf("fast request got %d", code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("fast request blocked by a debug request in flight")
+	}
+
+	close(release)
+	<-done
+}
+
+// testLogger records the logged messages
+type testLogger struct {
+	mu   sync.Mutex
+	msgs []string
+}
+
+func (l *testLogger) Debug(msg string, keyvals ...interface{}) { l.log("DEBUG", msg) }
+func (l *testLogger) Info(msg string, keyvals ...interface{})  { l.log("INFO", msg) }
+func (l *testLogger) Warn(msg string, keyvals ...interface{})  { l.log("WARN", msg) }
+func (l *testLogger) Error(msg string, keyvals ...interface{}) { l.log("ERROR", msg) }
+
+func (l *testLogger) log(level string, msg string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.msgs = append(l.msgs, level+" "+msg)
+}
+
+func (l *testLogger) logged(msg string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, m := range l.msgs {
+		if m == msg {
+			return true
+		}
+	}
+	return false
+}
+
+func TestResponseCookies(t *testing.T) {
+	pkg := testPackage(t)
+	e := NewEngine(WithStaticPackage(pkg, "v1", funcTunnel(func(route string, req string) string {
+		return `{"ok":true,"__meta__":{"cookies":[
+			{"name":"s","value":"v","path":"/","max-age":3600,"httpOnly":true,"secure":true,"sameSite":"Lax"},
+			{"name":"snake","value":"v","max_age":3600},
+			{"name":"bad","value":"v","sameSite":"sometimes"}
+		]}}`
+	})))
+
+	w := serve(e, http.MethodGet, "/api/"+pkg+"/v1/login", "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d %s", w.Code, w.Body.String())
+	}
+
+	cookies := w.Header().Values("Set-Cookie")
+	if want := "s=v; Path=/; Max-Age=3600; HttpOnly; Secure; SameSite=Lax"; len(cookies) != 1 || cookies[0] != want {
+		t.Fatalf("Set-Cookie %q, want %q", cookies, want)
+	}
+	if body := w.Body.String(); body != `{"ok":true}` {
+		t.Fatalf("body %s", body)
+	}
+}
+
+// flushRecorder counts the flushes of the response
+type flushRecorder struct {
+	*httptest.ResponseRecorder
+	flushes int
+}
+
+func (r *flushRecorder) Flush() {
+	r.flushes++
+	r.ResponseRecorder.Flush()
+}
+
+func TestWAPIStreamsLargeResponse(t *testing.T) {
+	body := strings.Repeat("0123456789abcdef", 16<<10)
+
+	pkg := testPackage(t)
+	e := NewEngine(WithStaticPackage(pkg, "v1", funcTunnel(func(route string, req string) string {
+		return fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nContent-Length: %d\r\n\r\n%s", len(body), body)
+	})))
+
+	w := &flushRecorder{ResponseRecorder: httptest.NewRecorder()}
+	e.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/wapi/"+pkg+"/v1/file", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d", w.Code)
+	}
+	if w.Body.String() != body {
+		t.Fatalf("body differs, got %d bytes, want %d", w.Body.Len(), len(body))
+	}
+	if want := len(body) / (32 << 10); w.flushes < want {
+		t.Fatalf("flushed %d times, want at least %d", w.flushes, want)
+	}
+}
+
+func TestWAPIChunkedResponse(t *testing.T) {
+	body := strings.Repeat("chunk", 10<<10)
+
+	var chunked bytes.Buffer
+	cw := httputil.NewChunkedWriter(&chunked)
+	cw.Write([]byte(body))
+	cw.Close()
+	chunked.WriteString("\r\n")
+
+	pkg := testPackage(t)
+	e := NewEngine(WithStaticPackage(pkg, "v1", funcTunnel(func(route string, req string) string {
+		return "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n" + chunked.String()
+	})))
+
+	w := serve(e, http.MethodGet, "/wapi/"+pkg+"/v1/file", "", nil)
+	if w.Code != http.StatusOK || w.Body.String() != body {
+		t.Fatalf("got %d with %d bytes, want %d bytes", w.Code, w.Body.Len(), len(body))
+	}
+	if te := w.Header().Get("Transfer-Encoding"); te != "chunked" {
+		t.Fatalf("Transfer-Encoding %q", te)
+	}
+	if cl := w.Header().Get("Content-Length"); cl != "" {
+		t.Fatalf("Content-Length %q", cl)
+	}
+}
+
+func TestWAPIDebugKeepsBody(t *testing.T) {
+	pkg := testPackage(t)
+	e := NewEngine(WithJSONDebug(), WithStaticPackage(pkg, "v1", funcTunnel(func(route string, req string) string {
+		return "HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nhello"
+	})))
+
+	w := serve(e, http.MethodGet, "/_/wapi/"+pkg+"/v1/file", "", nil)
+	if !strings.Contains(w.Body.String(), `"response":"hello"`) {
+		t.Fatalf("debug output %s", w.Body.String())
+	}
+}
+
+func TestPanicRecovery(t *testing.T) {
+	pkg := testPackage(t)
+	e := NewEngine(WithStaticPackage(pkg, "v1", funcTunnel(func(route string, req string) string { panic("boom") })))
+
+	if w := serve(e, http.MethodGet, "/api/"+pkg+"/v1/route", "", nil); w.Code != http.StatusInternalServerError || w.Body.String() != "panic: boom" {
+		t.Fatalf("api: got %d %s", w.Code, w.Body.String())
+	}
+	if w := serve(e, http.MethodGet, "/_/api/"+pkg+"/v1/route", "", nil); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Panic: panic: boom") {
+		t.Fatalf("debug: got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestPanicRecoveryDisabled(t *testing.T) {
+	pkg := testPackage(t)
+	e := NewEngine(WithPanicRecovery(false), WithStaticPackage(pkg, "v1", funcTunnel(func(route string, req string) string { panic("boom") })))
+
+	for _, path := range []string{"/api/" + pkg + "/v1/route", "/_/api/" + pkg + "/v1/route"} {
+		func() {
+			defer func() {
+				if v := recover(); v != "boom" {
+					t.Errorf("%s: got panic %v", path, v)
+				}
+			}()
+			serve(e, http.MethodGet, path, "", nil)
+		}()
+	}
+}
+
+func TestPanicStack(t *testing.T) {
+	var handlerStack string
+	pkg := testPackage(t)
+	panics := funcTunnel(func(route string, req string) string { panic("boom") })
+
+	e := NewEngine(WithStaticPackage(pkg, "v1", panics), WithPanicHandler(func(c *gin.Context, recovered interface{}) {
+		handlerStack = c.GetString(PanicStackContext)
+	}))
+	if w := serve(e, http.MethodGet, "/api/"+pkg+"/v1/route", "", nil); w.Body.String() != "panic: boom" {
+		t.Fatalf("api: got %s", w.Body.String())
+	}
+	if !strings.Contains(handlerStack, "TestPanicStack") {
+		t.Fatalf("panic handler stack %q", handlerStack)
+	}
+
+	w := serve(e, http.MethodGet, "/_/api/"+pkg+"/v1/route", "", nil)
+	if !strings.Contains(w.Body.String(), "Panic Stack: goroutine") || !strings.Contains(w.Body.String(), "TestPanicStack") {
+		t.Fatalf("debug output has no stack: %s", w.Body.String())
+	}
+
+	e = NewEngine(WithStaticPackage(pkg, "v1", panics), WithJSONDebug())
+	var output map[string]string
+	if err := json.Unmarshal(serve(e, http.MethodGet, "/_/api/"+pkg+"/v1/route", "", nil).Body.Bytes(), &output); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(output["panic_stack"], "TestPanicStack") {
+		t.Fatalf("json debug panic_stack %q", output["panic_stack"])
+	}
+}
+
+func gzipBody(t *testing.T, body string) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write([]byte(body)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestGzipRequest(t *testing.T) {
+	pkg := testPackage(t)
+	echo := WithStaticPackage(pkg, "v1", funcTunnel(func(route string, req string) string {
+		return gjson.Get(req, "name").String()
+	}))
+	body := gzipBody(t, `{"name":"gopher"}`)
+	header := http.Header{"Content-Encoding": {"gzip"}, "Content-Type": {"application/json"}}
+
+	for _, e := range []*Engine{NewEngine(echo), NewEngine(echo, WithMaxDecompressedSize(0))} {
+		if w := serve(e, http.MethodPost, "/api/"+pkg+"/v1/route", body, header); w.Code != http.StatusOK || w.Body.String() != "gopher" {
+			t.Fatalf("got %d %s", w.Code, w.Body.String())
+		}
+	}
+
+	e := NewEngine(echo, WithMaxDecompressedSize(8))
+	if w := serve(e, http.MethodPost, "/api/"+pkg+"/v1/route", body, header); w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("over the limit: got %d", w.Code)
+	}
+
+	e = NewEngine(echo)
+	if w := serve(e, http.MethodPost, "/api/"+pkg+"/v1/route", "not gzip", header); w.Code != http.StatusBadRequest {
+		t.Fatalf("invalid gzip: got %d", w.Code)
+	}
+}
+
+func TestPresignRedirect(t *testing.T) {
+	pkg := testPackage(t)
+	e := NewEngine(
+		WithStaticPackage(pkg, "v1", funcTunnel(func(route string, req string) string {
+			if route == "/fail" {
+				return `{"__meta__":{"presign":"bucket/missing"}}`
+			}
+			return `{"__meta__":{"presign":"bucket/key"}}`
+		})),
+		WithPresignRedirect(func(key string) (string, error) {
+			if key == "bucket/missing" {
+				return "", errors.New("no such key")
+			}
+			return "https://s3.example.com/" + key + "?X-Amz-Signature=sig", nil
+		}),
+	)
+
+	w := serve(e, http.MethodGet, "/api/"+pkg+"/v1/object", "", nil)
+	if w.Code != http.StatusTemporaryRedirect {
+		t.Fatalf("got %d %s", w.Code, w.Body.String())
+	}
+	if location := w.Header().Get("Location"); location != "https://s3.example.com/bucket/key?X-Amz-Signature=sig" {
+		t.Fatalf("Location %q", location)
+	}
+
+	if w := serve(e, http.MethodGet, "/api/"+pkg+"/v1/fail", "", nil); w.Code != http.StatusInternalServerError || w.Body.String() != "no such key" {
+		t.Fatalf("signer error: got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestResponseMetaDepth(t *testing.T) {
+	pkg := testPackage(t)
+	logger := &testLogger{}
+	e := NewEngine(WithLogger(logger), WithStaticPackage(pkg, "v1", funcTunnel(func(route string, req string) string {
+		switch route {
+		case "/nested":
+			return `{"ok":true,"__meta__":{"etag":"v1","cookies":[{"name":"s","value":"v"}]}}`
+		case "/deep":
+			return `{"ok":true,"__meta__":{"etag":"v1","extra":{"a":{"b":{"c":1}}}}}`
+		default:
+			return `{"ok":true,"__meta__":{"etag":1,"warning":"partial"}}`
+		}
+	})))
+
+	w := serve(e, http.MethodGet, "/api/"+pkg+"/v1/nested", "", nil)
+	if w.Header().Get("ETag") != `"v1"` || w.Header().Get("Set-Cookie") != "s=v" || w.Body.String() != `{"ok":true}` {
+		t.Fatalf("nested meta: got %v %s", w.Header(), w.Body.String())
+	}
+
+	w = serve(e, http.MethodGet, "/api/"+pkg+"/v1/deep", "", nil)
+	if w.Code != http.StatusOK || w.Header().Get("ETag") != "" || w.Body.String() != `{"ok":true}` {
+		t.Fatalf("over-deep meta: got %d %v %s", w.Code, w.Header(), w.Body.String())
+	}
+	if !logger.logged("WARN response meta ignored") {
+		t.Fatal("over-deep meta not logged")
+	}
+
+	w = serve(e, http.MethodGet, "/api/"+pkg+"/v1/typed", "", nil)
+	if w.Header().Get("ETag") != "" || w.Header().Get("Warning") == "" {
+		t.Fatalf("mistyped meta: got %v", w.Header())
+	}
+}
+
+func TestHeaderLinkOrder(t *testing.T) {
+	pkg := testPackage(t)
+	e := NewEngine(
+		WithStaticPackage(pkg, "v1", versionTunnel("v1")),
+		WithStaticPackage(pkg, "v2", versionTunnel("v2")),
+		WithHeaderLinkKey("X-First", "/api/"+pkg+"/v1"),
+		WithHeaderLinkKey("X-Second", "/api/"+pkg+"/v2"),
+	)
+
+	for i := 0; i < 10; i++ {
+		w := serve(e, http.MethodGet, "/", "", http.Header{"X-First": {"route"}, "X-Second": {"route"}})
+		if w.Body.String() != "v1" {
+			t.Fatalf("got %s, want the first registered link", w.Body.String())
+		}
+	}
+	if w := serve(e, http.MethodGet, "/", "", http.Header{"X-Second": {"route"}}); w.Body.String() != "v2" {
+		t.Fatalf("second link: got %s", w.Body.String())
+	}
+}
+
+func TestConditionalResponse(t *testing.T) {
+	pkg := testPackage(t)
+	e := NewEngine(WithStaticPackage(pkg, "v1", funcTunnel(func(route string, req string) string {
+		return `{"ok":true,"__meta__":{"etag":"abc","last_modified":"2022-01-02T15:04:05Z"}}`
+	})))
+	path := "/api/" + pkg + "/v1/route"
+
+	w := serve(e, http.MethodGet, path, "", nil)
+	if w.Code != http.StatusOK || w.Body.String() != `{"ok":true}` {
+		t.Fatalf("fresh: got %d %s", w.Code, w.Body.String())
+	}
+	if w.Header().Get("ETag") != `"abc"` || w.Header().Get("Last-Modified") != "Sun, 02 Jan 2022 15:04:05 GMT" {
+		t.Fatalf("fresh: headers %v", w.Header())
+	}
+
+	for _, tc := range []struct {
+		name   string
+		header http.Header
+		code   int
+	}{
+		{"if-modified-since at", http.Header{"If-Modified-Since": {"Sun, 02 Jan 2022 15:04:05 GMT"}}, http.StatusNotModified},
+		{"if-modified-since after", http.Header{"If-Modified-Since": {"Mon, 03 Jan 2022 00:00:00 GMT"}}, http.StatusNotModified},
+		{"if-modified-since before", http.Header{"If-Modified-Since": {"Sat, 01 Jan 2022 00:00:00 GMT"}}, http.StatusOK},
+		{"if-none-match", http.Header{"If-None-Match": {`"abc"`}}, http.StatusNotModified},
+		{"etag wins", http.Header{"If-None-Match": {`"other"`}, "If-Modified-Since": {"Mon, 03 Jan 2022 00:00:00 GMT"}}, http.StatusOK},
+	} {
+		if w := serve(e, http.MethodGet, path, "", tc.header); w.Code != tc.code {
+			t.Errorf("%s: got %d, want %d", tc.name, w.Code, tc.code)
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	pkg := testPackage(t)
+	e := NewEngine(
+		WithStaticPackage(pkg, "v1", versionTunnel("v1")),
+		WithMiddleware(func(c *gin.Context) {
+			c.Header("X-Middleware", "yes")
+		}),
+	)
+
+	for _, path := range []string{"/health-check", "/api/" + pkg + "/v1/route", "/wapi/" + pkg + "/v1/route"} {
+		if w := serve(e, http.MethodGet, path, "", nil); w.Header().Get("X-Middleware") != "yes" {
+			t.Errorf("%s: middleware header missing", path)
+		}
+	}
+}
+
+// failingCodec fails to encode GET requests
+type failingCodec struct {
+	JSONCodec
+}
+
+func (failingCodec) Encode(v map[string]interface{}) (string, error) {
+	return "", errors.New("encode failed")
+}
+
+func TestRequestEncodeError(t *testing.T) {
+	pkg := testPackage(t)
+	logger := &testLogger{}
+	e := NewEngine(WithLogger(logger), WithCodec(failingCodec{}), WithStaticPackage(pkg, "v1", versionTunnel("v1")))
+
+	w := serve(e, http.MethodGet, "/api/"+pkg+"/v1/route?a=1", "", nil)
+	if w.Code != http.StatusInternalServerError || w.Body.String() != "encode failed" {
+		t.Fatalf("got %d %s", w.Code, w.Body.String())
+	}
+	if !logger.logged("ERROR generate request failed") {
+		t.Fatal("error not logged")
+	}
+
+	// the engine keeps serving
+	if w := serve(e, http.MethodPost, "/api/"+pkg+"/v1/route", "{}", nil); w.Code != http.StatusOK {
+		t.Fatalf("next request: got %d", w.Code)
+	}
+}
+
+func TestWarningHeaders(t *testing.T) {
+	pkg := testPackage(t)
+	e := NewEngine(WithStaticPackage(pkg, "v1", funcTunnel(func(route string, req string) string {
+		return `{"items":[1],"__meta__":{"warning":"inventory unavailable"}}`
+	})))
+
+	w := serve(e, http.MethodGet, "/api/"+pkg+"/v1/route", "", nil)
+	if w.Code != http.StatusOK || w.Body.String() != `{"items":[1]}` {
+		t.Fatalf("got %d %s", w.Code, w.Body.String())
+	}
+	if warning := w.Header().Get("Warning"); warning != `199 - "inventory unavailable"` {
+		t.Fatalf("Warning %q", warning)
+	}
+	if w.Header().Get("X-Partial") != "true" {
+		t.Fatal("X-Partial missing")
+	}
+}
+
+// metaTunnel responds with the request meta field at key
+func metaTunnel(key string) funcTunnel {
+	return func(route string, req string) string {
+		return gjson.Get(req, "__meta__."+key).String()
+	}
+}
+
+func TestCorrelationHeader(t *testing.T) {
+	pkg := testPackage(t)
+	e := NewEngine(WithCorrelationHeader("X-Correlation-ID"), WithStaticPackage(pkg, "v1", metaTunnel(MetaCorrelationID)))
+
+	w := serve(e, http.MethodPost, "/api/"+pkg+"/v1/route", "{}", http.Header{"X-Correlation-Id": {"abc-123"}})
+	if w.Body.String() != "abc-123" || w.Header().Get("X-Correlation-ID") != "abc-123" {
+		t.Fatalf("present: got %s %v", w.Body.String(), w.Header())
+	}
+
+	w = serve(e, http.MethodPost, "/api/"+pkg+"/v1/route", "{}", nil)
+	id := w.Header().Get("X-Correlation-ID")
+	if len(id) != 36 || w.Body.String() != id {
+		t.Fatalf("absent: got %q in meta and %q echoed", w.Body.String(), id)
+	}
+}
+
+// errReader fails every read
+type errReader struct{}
+
+func (errReader) Read(p []byte) (int, error) {
+	return 0, errors.New("read failed")
+}
+
+func TestRequestReadError(t *testing.T) {
+	var invoked bool
+	pkg := testPackage(t)
+	e := NewEngine(WithLogger(&testLogger{}), WithStaticPackage(pkg, "v1", funcTunnel(func(route string, req string) string {
+		invoked = true
+		return "ok"
+	})))
+
+	r := httptest.NewRequest(http.MethodPost, "/api/"+pkg+"/v1/route", errReader{})
+	w := httptest.NewRecorder()
+	e.ServeHTTP(w, r)
+
+	if w.Code != http.StatusInternalServerError || w.Body.String() != "read failed" {
+		t.Fatalf("got %d %s", w.Code, w.Body.String())
+	}
+	if invoked {
+		t.Fatal("package invoked without a request")
+	}
+	if w := serve(e, http.MethodPost, "/api/"+pkg+"/v1/route", "{}", nil); w.Code != http.StatusOK {
+		t.Fatalf("next request: got %d", w.Code)
+	}
+}
+
+// echoTunnel responds with the request
+var echoTunnel = funcTunnel(func(route string, req string) string { return req })
+
+func TestEmptyQueryPolicy(t *testing.T) {
+	pkg := testPackage(t)
+	path := "/api/" + pkg + "/v1/route"
+
+	e := NewEngine(WithStaticPackage(pkg, "v1", echoTunnel))
+	// the echoed request meta is taken apart as response meta
+	if rsp := serve(e, http.MethodGet, path, "", nil).Body.String(); rsp != "{}" {
+		t.Errorf("EmptyObject: got %q", rsp)
+	}
+
+	e = NewEngine(WithStaticPackage(pkg, "v1", echoTunnel), WithEmptyQueryPolicy(EmptyString))
+	if rsp := serve(e, http.MethodGet, path, "", nil).Body.String(); rsp != "" {
+		t.Errorf("EmptyString: got %q", rsp)
+	}
+
+	e = NewEngine(WithStaticPackage(pkg, "v1", echoTunnel), WithEmptyQueryPolicy(Null))
+	if rsp := serve(e, http.MethodGet, path, "", nil).Body.String(); rsp != "null" {
+		t.Errorf("Null: got %q", rsp)
+	}
+
+	// a query is still encoded as an object
+	if rsp := serve(e, http.MethodGet, path+"?a=1", "", nil).Body.String(); gjson.Get(rsp, "a").String() != "1" {
+		t.Errorf("query: got %s", rsp)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	pkg := testPackage(t)
+	e := NewEngine(WithRequestID(""), WithStaticPackage(pkg, "v1", metaTunnel(MetaRequestID)))
+
+	w := serve(e, http.MethodPost, "/api/"+pkg+"/v1/route", "{}", http.Header{"X-Request-Id": {"req-1"}})
+	if w.Body.String() != "req-1" || w.Header().Get("X-Request-Id") != "req-1" {
+		t.Fatalf("pass-through: got %s %v", w.Body.String(), w.Header())
+	}
+
+	w = serve(e, http.MethodPost, "/api/"+pkg+"/v1/route", "{}", nil)
+	id := w.Header().Get("X-Request-Id")
+	if len(id) != 36 || id[14] != '4' || w.Body.String() != id {
+		t.Fatalf("generated: got %q in meta and %q echoed", w.Body.String(), id)
+	}
+}
+
+// rawMetaTunnel responds with the whole request meta
+var rawMetaTunnel = funcTunnel(func(route string, req string) string {
+	return gjson.Get(req, "__meta__").Raw
+})
+
+func TestForwardHeaders(t *testing.T) {
+	pkg := testPackage(t)
+	e := NewEngine(WithForwardHeaders("Accept-Language", "X-Tenant"), WithStaticPackage(pkg, "v1", rawMetaTunnel))
+
+	w := serve(e, http.MethodPost, "/api/"+pkg+"/v1/route", "{}", http.Header{"Accept-Language": {"de-DE"}})
+	if lang := gjson.Get(w.Body.String(), "accept_language"); lang.String() != "de-DE" {
+		t.Fatalf("got %s", w.Body.String())
+	}
+	if gjson.Get(w.Body.String(), "x_tenant").Exists() {
+		t.Fatalf("missing header forwarded: %s", w.Body.String())
+	}
+}
+
+func TestAPIGatewayV2Meta(t *testing.T) {
+	pkg := testPackage(t)
+	requestContext := `{"http":{"sourceIp":"203.0.113.7","method":"PUT","protocol":"HTTP/2.0"}}`
+	header := http.Header{"X-Amzn-Request-Context": {requestContext}}
+
+	w := serve(NewEngine(WithAPIGatewayV2(), WithStaticPackage(pkg, "v1", rawMetaTunnel)), http.MethodPost, "/api/"+pkg+"/v1/route", "{}", header)
+	meta := w.Body.String()
+	if gjson.Get(meta, MetaRemoteAddr).String() != "203.0.113.7" || gjson.Get(meta, MetaHTTPMethod).String() != "PUT" || gjson.Get(meta, MetaProtocol).String() != "HTTP/2.0" {
+		t.Fatalf("got %s", meta)
+	}
+
+	// without the option the header is ignored
+	w = serve(NewEngine(WithStaticPackage(pkg+"-off", "v1", rawMetaTunnel)), http.MethodPost, "/api/"+pkg+"-off/v1/route", "{}", header)
+	meta = w.Body.String()
+	if gjson.Get(meta, MetaRemoteAddr).String() == "203.0.113.7" || gjson.Get(meta, MetaProtocol).Exists() {
+		t.Fatalf("got %s", meta)
+	}
+}
+
+func TestPanicHandler(t *testing.T) {
+	pkg := testPackage(t)
+	var recovered interface{}
+	var stack string
+	e := NewEngine(
+		WithStaticPackage(pkg, "v1", funcTunnel(func(route string, req string) string { panic("boom") })),
+		WithPanicHandler(func(c *gin.Context, v interface{}) {
+			recovered, stack = v, c.GetString(PanicStackContext)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal"})
+		}),
+	)
+
+	w := serve(e, http.MethodGet, "/api/"+pkg+"/v1/route", "", nil)
+	if w.Code != http.StatusInternalServerError || w.Body.String() != `{"error":"internal"}` {
+		t.Fatalf("got %d %s", w.Code, w.Body.String())
+	}
+	if recovered != "boom" || !strings.Contains(stack, "goroutine") {
+		t.Fatalf("handler got %v %q", recovered, stack)
+	}
+}
+
+func TestPrependMiddleware(t *testing.T) {
+	pkg := testPackage(t)
+	var order []string
+	record := func(name string) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			// the request id is set by a built-in middleware
+			order = append(order, fmt.Sprintf("%s:%t", name, c.GetString(RequestIDContext) != ""))
+		}
+	}
+	e := NewEngine(
+		WithRequestID(""),
+		WithStaticPackage(pkg, "v1", versionTunnel("v1")),
+		WithMiddleware(record("append")),
+		WithPrependMiddleware(record("prepend1"), record("prepend2")),
+	)
+
+	if w := serve(e, http.MethodGet, "/api/"+pkg+"/v1/route", "", nil); w.Code != http.StatusOK {
+		t.Fatalf("got %d", w.Code)
+	}
+	if got := strings.Join(order, " "); got != "prepend1:false prepend2:false append:true" {
+		t.Fatalf("order %s", got)
+	}
+}
+
+func TestWAPIMultipleCookies(t *testing.T) {
+	pkg := testPackage(t)
+	e := NewEngine(WithStaticPackage(pkg, "v1", funcTunnel(func(route string, req string) string {
+		return "HTTP/1.1 200 OK\r\nSet-Cookie: a=1; Path=/\r\nSet-Cookie: b=2; HttpOnly\r\nContent-Length: 2\r\n\r\nok"
+	})))
+
+	w := serve(e, http.MethodGet, "/wapi/"+pkg+"/v1/login", "", nil)
+	if w.Code != http.StatusOK || w.Body.String() != "ok" {
+		t.Fatalf("got %d %s", w.Code, w.Body.String())
+	}
+	if cookies := w.Header().Values("Set-Cookie"); len(cookies) != 2 || cookies[0] != "a=1; Path=/" || cookies[1] != "b=2; HttpOnly" {
+		t.Fatalf("Set-Cookie %q", cookies)
+	}
+}
+
+func TestResponseTransform(t *testing.T) {
+	pkg := testPackage(t)
+	e := NewEngine(
+		WithStaticPackage(pkg, "v1", funcTunnel(func(route string, req string) string {
+			switch route {
+			case "/redirect":
+				return "https://example.com/next"
+			case "/error":
+				return "error://failed"
+			case "/reject":
+				return "reject"
+			}
+			return `{"ok":true,"__meta__":{"content_type":"application/json"}}`
+		})),
+		WithResponseTransform(func(path string, body string) (string, error) {
+			if body == "reject" {
+				return "", errors.New("transform failed")
+			}
+			return `{"path":"` + path + `","data":` + body + `}`, nil
+		}),
+	)
+	path := "/api/" + pkg + "/v1/"
+
+	w := serve(e, http.MethodGet, path+"route", "", nil)
+	if w.Code != http.StatusOK || w.Body.String() != `{"path":"/`+pkg+`/v1/route","data":{"ok":true}}` || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("wrapped: got %d %s", w.Code, w.Body.String())
+	}
+	if w := serve(e, http.MethodGet, path+"redirect", "", nil); w.Code != http.StatusTemporaryRedirect || w.Header().Get("Location") != "https://example.com/next" {
+		t.Fatalf("redirect: got %d %v", w.Code, w.Header())
+	}
+	if w := serve(e, http.MethodGet, path+"error", "", nil); w.Code != http.StatusInternalServerError || w.Body.String() != "failed" {
+		t.Fatalf("error: got %d %s", w.Code, w.Body.String())
+	}
+	if w := serve(e, http.MethodGet, path+"reject", "", nil); w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), "transform failed") {
+		t.Fatalf("transform error: got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestDebugTrigger(t *testing.T) {
+	pkg := testPackage(t)
+	e := NewEngine(
+		WithDebugPrefix("/debug/"),
+		WithDebugHeader("x-debug"),
+		WithStaticPackage(pkg, "v1", versionTunnel("v1")),
+	)
+	isDebug := func(w *httptest.ResponseRecorder) bool {
+		return strings.Contains(w.Body.String(), "Path: /"+pkg+"/v1/route\n")
+	}
+
+	if w := serve(e, http.MethodGet, "/debug/api/"+pkg+"/v1/route", "", nil); w.Code != http.StatusOK || !isDebug(w) {
+		t.Fatalf("prefix: got %d %s", w.Code, w.Body.String())
+	}
+	if w := serve(e, http.MethodGet, "/_/api/"+pkg+"/v1/route", "", nil); w.Code != http.StatusNotFound {
+		t.Fatalf("default prefix: got %d", w.Code)
+	}
+	if w := serve(e, http.MethodGet, "/api/"+pkg+"/v1/route", "", http.Header{"X-Debug": {"true"}}); w.Code != http.StatusOK || !isDebug(w) {
+		t.Fatalf("header: got %d %s", w.Code, w.Body.String())
+	}
+	if w := serve(e, http.MethodGet, "/api/"+pkg+"/v1/route", "", http.Header{"X-Debug": {"no"}}); w.Body.String() != "v1" {
+		t.Fatalf("header off: got %s", w.Body.String())
+	}
+}
+
+func TestRequestValidator(t *testing.T) {
+	pkg := testPackage(t)
+	var invoked int
+	e := NewEngine(
+		WithStaticPackage(pkg, "v1", funcTunnel(func(route string, req string) string {
+			invoked++
+			return "ok"
+		})),
+		WithRequestValidator(func(path string, req string) error {
+			if !gjson.Get(req, "id").Exists() {
+				return fmt.Errorf("%s: id is required", path)
+			}
+			return nil
+		}),
+	)
+
+	for _, prefix := range []string{"/api/", "/wapi/"} {
+		w := serve(e, http.MethodPost, prefix+pkg+"/v1/route", `{"name":"x"}`, nil)
+		if w.Code != http.StatusBadRequest || w.Body.String() != "/"+pkg+"/v1/route: id is required" {
+			t.Fatalf("%s invalid: got %d %s", prefix, w.Code, w.Body.String())
+		}
+	}
+	if invoked != 0 {
+		t.Fatalf("invalid requests invoked the tunnel %d times", invoked)
+	}
+
+	if w := serve(e, http.MethodPost, "/api/"+pkg+"/v1/route", `{"id":1}`, nil); w.Code != http.StatusOK || w.Body.String() != "ok" {
+		t.Fatalf("valid: got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestJSONDebug(t *testing.T) {
+	pkg := testPackage(t)
+	e := NewEngine(WithStaticPackage(pkg, "v1", printTunnel{}), WithJSONDebug())
+
+	w := serve(e, http.MethodGet, "/_/api/"+pkg+"/v1/route?a=1", "", nil)
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+		t.Fatalf("got %d %v", w.Code, w.Header())
+	}
+	var output map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &output); err != nil {
+		t.Fatalf("%v: %s", err, w.Body.String())
+	}
+
+	for _, key := range []string{"mode", "raw_path", "path", "canary", "param", "request", "response", "error", "panic_stack", "stdout", "stderr"} {
+		if _, ok := output[key]; !ok {
+			t.Errorf("missing %s", key)
+		}
+	}
+	for key, want := range map[string]string{
+		"mode":     "api",
+		"raw_path": "/_/api/" + pkg + "/v1/route",
+		"path":     "/" + pkg + "/v1/route",
+		"param":    "/route",
+		"response": "/route",
+		"error":    "",
+		"stdout":   "out /route\n",
+		"stderr":   "err /route\n",
+	} {
+		if output[key] != want {
+			t.Errorf("%s: got %q, want %q", key, output[key], want)
+		}
+	}
+	if gjson.Get(output["request"], "a").String() != "1" {
+		t.Errorf("request: got %s", output["request"])
+	}
+}
+
+func TestDebugCaptureDisabled(t *testing.T) {
+	pkg := testPackage(t)
+	e := NewEngine(WithStaticPackage(pkg, "v1", printTunnel{}), WithJSONDebug(), WithDebugCapture(false))
+
+	var output map[string]string
+	if err := json.Unmarshal(serve(e, http.MethodGet, "/_/api/"+pkg+"/v1/route", "", nil).Body.Bytes(), &output); err != nil {
+		t.Fatal(err)
+	}
+	if output["stdout"] != "" || output["stderr"] != "" {
+		t.Fatalf("captured %q %q", output["stdout"], output["stderr"])
+	}
+	if output["mode"] != "api" || output["path"] != "/"+pkg+"/v1/route" || output["response"] != "/route" {
+		t.Fatalf("got %v", output)
+	}
+}
+
+func TestRequestTransform(t *testing.T) {
+	pkg := testPackage(t)
+	var invoked int
+	e := NewEngine(
+		WithStaticPackage(pkg, "v1", funcTunnel(func(route string, req string) string {
+			invoked++
+			return gjson.Get(req, "tenant").String()
+		})),
+		WithRequestTransform(func(ctx context.Context, path string, req string) (string, error) {
+			if strings.HasSuffix(path, "/reject") {
+				return "", errors.New("transform rejected")
+			}
+			return sjson.Set(req, "tenant", "acme")
+		}),
+	)
+
+	if w := serve(e, http.MethodPost, "/api/"+pkg+"/v1/route", `{"name":"x"}`, nil); w.Code != http.StatusOK || w.Body.String() != "acme" {
+		t.Fatalf("got %d %s", w.Code, w.Body.String())
+	}
+
+	invoked = 0
+	w := serve(e, http.MethodPost, "/api/"+pkg+"/v1/reject", `{"name":"x"}`, nil)
+	if w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), "transform rejected") || invoked != 0 {
+		t.Fatalf("rejected: got %d %s, invoked %d times", w.Code, w.Body.String(), invoked)
+	}
+}
+
+func TestRawResponseTransform(t *testing.T) {
+	pkg := testPackage(t)
+	e := NewEngine(
+		WithStaticPackage(pkg, "v1", funcTunnel(func(route string, req string) string {
+			if route == "/meta" {
+				return `{"ok":true,"__meta__":{"content_type":"application/json"}}`
+			}
+			return `[1,2]`
+		})),
+		WithRawResponseTransform(func(ctx context.Context, path string, rsp string) (string, error) {
+			if gjson.Get(rsp, "__meta__").Exists() {
+				return rsp, nil
+			}
+			return `{"data":` + rsp + `,"__meta__":{"etag":"abc"}}`, nil
+		}),
+	)
+
+	// the wrapped response carries meta of its own
+	w := serve(e, http.MethodGet, "/api/"+pkg+"/v1/route", "", nil)
+	if w.Code != http.StatusOK || w.Body.String() != `{"data":[1,2]}` || w.Header().Get("ETag") != `"abc"` {
+		t.Fatalf("wrapped: got %d %v %s", w.Code, w.Header(), w.Body.String())
+	}
+	w = serve(e, http.MethodGet, "/api/"+pkg+"/v1/meta", "", nil)
+	if w.Body.String() != `{"ok":true}` || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("meta: got %v %s", w.Header(), w.Body.String())
+	}
+}
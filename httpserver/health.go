@@ -0,0 +1,80 @@
+package httpserver
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/aura-studio/dynamic"
+	"github.com/gin-gonic/gin"
+)
+
+// HealthCheckable is optionally implemented by tunnels to take part in
+// the deep health check
+type HealthCheckable interface {
+	Health() error
+}
+
+func (e *Engine) HealthCheck(c *gin.Context) {
+	if e.DeepHealthCheck {
+		if errs := e.checkPackages(true); len(errs) > 0 {
+			c.String(http.StatusServiceUnavailable, strings.Join(errs, "\n"))
+			c.Abort()
+			return
+		}
+	}
+
+	if e.BuildInfo == nil || e.BuildInfo.String() == "" {
+		e.OK(c)
+		return
+	}
+
+	c.String(http.StatusOK, "OK "+e.BuildInfo.String())
+	c.Abort()
+}
+
+// Ready answers 503 until every static and preloaded package resolves,
+// unlike /health-check it never reports OK before the packages are loaded
+func (e *Engine) Ready(c *gin.Context) {
+	if errs := e.checkPackages(false); len(errs) > 0 {
+		c.String(http.StatusServiceUnavailable, strings.Join(errs, "\n"))
+		c.Abort()
+		return
+	}
+
+	e.OK(c)
+}
+
+// Version reports the configured build info
+func (e *Engine) Version(c *gin.Context) {
+	c.JSON(http.StatusOK, e.BuildInfo)
+	c.Abort()
+}
+
+// checkPackages reports every static or preloaded package which can't be
+// resolved or, when health is set, whose tunnel is unhealthy
+func (e *Engine) checkPackages(health bool) []string {
+	status := "unhealthy"
+	if !health {
+		status = "not ready"
+	}
+
+	var errs []string
+	for _, packages := range [][]*Package{e.StaticPackages, e.PreloadPackages} {
+		for _, p := range packages {
+			tunnel, err := dynamic.GetPackage(p.Name, p.Commit)
+			if err == nil && tunnel == nil {
+				err = fmt.Errorf("not found")
+			}
+			if err == nil && health {
+				if h, ok := tunnel.(HealthCheckable); ok {
+					err = h.Health()
+				}
+			}
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("package %s@%s %s: %v", p.Name, p.Commit, status, err))
+			}
+		}
+	}
+	return errs
+}
@@ -0,0 +1,80 @@
+package httpserver
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/aura-studio/dynamic"
+)
+
+// healthTunnel reports err from the deep health check
+type healthTunnel struct {
+	funcTunnel
+	err error
+}
+
+func (t healthTunnel) Health() error { return t.err }
+
+func TestDeepHealthCheck(t *testing.T) {
+	pkg := testPackage(t)
+	ok := funcTunnel(func(route string, req string) string { return "ok" })
+	e := NewEngine(
+		WithDeepHealthCheck(),
+		WithStaticPackage(pkg+"-healthy", "v1", healthTunnel{funcTunnel: ok}),
+		WithStaticPackage(pkg+"-unhealthy", "v1", healthTunnel{funcTunnel: ok, err: errors.New("database unreachable")}),
+	)
+
+	w := serve(e, http.MethodGet, "/health-check", "", nil)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("got %d %s", w.Code, w.Body.String())
+	}
+	if body := w.Body.String(); !strings.Contains(body, pkg+"-unhealthy@v1") || !strings.Contains(body, "database unreachable") || strings.Contains(body, pkg+"-healthy@") {
+		t.Fatalf("got %s", body)
+	}
+
+	// the shallow check doesn't look at the tunnels
+	e = NewEngine(WithStaticPackage(pkg+"-unhealthy", "v1", healthTunnel{funcTunnel: ok, err: errors.New("database unreachable")}))
+	if w := serve(e, http.MethodGet, "/health-check", "", nil); w.Code != http.StatusOK || w.Body.String() != "OK" {
+		t.Fatalf("shallow: got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestVersion(t *testing.T) {
+	e := NewEngine()
+	if w := serve(e, http.MethodGet, "/health-check", "", nil); w.Code != http.StatusOK || w.Body.String() != "OK" {
+		t.Fatalf("unset health-check: got %d %s", w.Code, w.Body.String())
+	}
+	if w := serve(e, http.MethodGet, "/version", "", nil); w.Code != http.StatusNotFound {
+		t.Fatalf("unset version: got %d", w.Code)
+	}
+
+	e = NewEngine(WithBuildInfo(BuildInfo{Version: "1.2.3", Commit: "abc123", BuildTime: "2022-01-02T15:04:05Z"}))
+	w := serve(e, http.MethodGet, "/version", "", nil)
+	if w.Code != http.StatusOK || w.Body.String() != `{"version":"1.2.3","commit":"abc123","build_time":"2022-01-02T15:04:05Z"}` {
+		t.Fatalf("version: got %d %s", w.Code, w.Body.String())
+	}
+	if w := serve(e, http.MethodGet, "/health-check", "", nil); w.Body.String() != "OK 1.2.3 abc123 2022-01-02T15:04:05Z" {
+		t.Fatalf("health-check: got %s", w.Body.String())
+	}
+}
+
+func TestReady(t *testing.T) {
+	pkg := testPackage(t)
+	e := NewEngine(WithPreloadPackage(pkg, "v1"), WithLogger(&testLogger{}))
+
+	w := serve(e, http.MethodGet, "/ready", "", nil)
+	if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), pkg+"@v1 not ready") {
+		t.Fatalf("before: got %d %s", w.Code, w.Body.String())
+	}
+	// unlike /ready the health check doesn't wait for the packages
+	if w := serve(e, http.MethodGet, "/health-check", "", nil); w.Code != http.StatusOK {
+		t.Fatalf("health-check: got %d", w.Code)
+	}
+
+	dynamic.RegisterPackage(pkg, "v1", versionTunnel("v1"))
+	if w := serve(e, http.MethodGet, "/ready", "", nil); w.Code != http.StatusOK || w.Body.String() != "OK" {
+		t.Fatalf("after: got %d %s", w.Code, w.Body.String())
+	}
+}
@@ -26,4 +26,8 @@ func Close() {
 		log.Fatal(err)
 	}
 	defer cancel()
+
+	if e, ok := srv.Handler.(*Engine); ok {
+		e.Stop()
+	}
 }
@@ -0,0 +1,50 @@
+package httpserver
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Logger is a leveled logger taking a message followed by alternating
+// key-value fields
+type Logger interface {
+	Debug(msg string, keyvals ...interface{})
+	Info(msg string, keyvals ...interface{})
+	Warn(msg string, keyvals ...interface{})
+	Error(msg string, keyvals ...interface{})
+}
+
+// StdLogger writes to the standard log package
+type StdLogger struct{}
+
+func (l *StdLogger) Debug(msg string, keyvals ...interface{}) {
+	l.output("DEBUG", msg, keyvals...)
+}
+
+func (l *StdLogger) Info(msg string, keyvals ...interface{}) {
+	l.output("INFO", msg, keyvals...)
+}
+
+func (l *StdLogger) Warn(msg string, keyvals ...interface{}) {
+	l.output("WARN", msg, keyvals...)
+}
+
+func (l *StdLogger) Error(msg string, keyvals ...interface{}) {
+	l.output("ERROR", msg, keyvals...)
+}
+
+func (l *StdLogger) output(level string, msg string, keyvals ...interface{}) {
+	var buf strings.Builder
+	buf.WriteString(level)
+	buf.WriteString(" ")
+	buf.WriteString(msg)
+	for i := 0; i < len(keyvals); i += 2 {
+		if i+1 < len(keyvals) {
+			buf.WriteString(fmt.Sprintf(" %v=%v", keyvals[i], keyvals[i+1]))
+		} else {
+			buf.WriteString(fmt.Sprintf(" %v=", keyvals[i]))
+		}
+	}
+	log.Print(buf.String())
+}
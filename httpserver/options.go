@@ -1,8 +1,17 @@
 package httpserver
 
 import (
+	"context"
+	"crypto/rsa"
+	"net/http"
+	"strings"
+	"time"
+
 	"github.com/aura-studio/dynamic"
+	"github.com/gin-gonic/gin"
 	"github.com/mohae/deepcopy"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/time/rate"
 )
 
 type Package struct {
@@ -11,14 +20,172 @@ type Package struct {
 	Tunnel dynamic.Tunnel
 }
 
+type HeaderLink struct {
+	Key    string `json:"key"`
+	Prefix string `json:"prefix"`
+}
+
+type StaticResponse struct {
+	Status      int
+	ContentType string
+	Body        []byte
+}
+
+// EmptyQueryPolicy decides the request sent for a GET without query params
+type EmptyQueryPolicy int
+
+const (
+	EmptyObject EmptyQueryPolicy = iota // {}
+	EmptyString                         // ""
+	Null                                // null
+)
+
+// RootMode builds the handler serving the root path
+type RootMode func(e *Engine) gin.HandlerFunc
+
+func RootOK() RootMode {
+	return func(e *Engine) gin.HandlerFunc {
+		return e.OK
+	}
+}
+
+func RootNotFound() RootMode {
+	return func(e *Engine) gin.HandlerFunc {
+		return e.PageNotFound
+	}
+}
+
+func RootRedirect(to string) RootMode {
+	return func(e *Engine) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			c.Redirect(http.StatusFound, to)
+			c.Abort()
+		}
+	}
+}
+
+func RootCustom(handler gin.HandlerFunc) RootMode {
+	return func(e *Engine) gin.HandlerFunc {
+		return handler
+	}
+}
+
+// BodyLimits bounds the request and response sizes of an invocation,
+// zero means unlimited
+type BodyLimits struct {
+	MaxRequestBytes  int64 `json:"max_request_bytes"`
+	MaxResponseBytes int64 `json:"max_response_bytes"`
+}
+
+// PackageSpec names a package version to preload
+type PackageSpec struct {
+	Name   string
+	Commit string
+}
+
+// PreloadPolicy decides what happens when a package fails to preload
+type PreloadPolicy int
+
+const (
+	PreloadLog      PreloadPolicy = iota // log the failure and keep starting
+	PreloadFailFast                      // panic once the preload finished
+)
+
+// BuildInfo describes the deployed build, served by /version
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildTime string `json:"build_time"`
+}
+
+// String returns the compact form reported by /health-check
+func (b BuildInfo) String() string {
+	var strs []string
+	for _, s := range []string{b.Version, b.Commit, b.BuildTime} {
+		if s != "" {
+			strs = append(strs, s)
+		}
+	}
+	return strings.Join(strs, " ")
+}
+
+// TrailingSlashPolicy decides how paths ending with a slash are routed
+type TrailingSlashPolicy int
+
+const (
+	TrailingSlashDefault  TrailingSlashPolicy = iota // gin default
+	TrailingSlashRedirect                            // redirect to the path without trailing slash
+	TrailingSlashRewrite                             // route as the path without trailing slash
+	TrailingSlashNone                                // never redirect
+)
+
 type Options struct {
-	ReleaseMode     bool
-	Namespace       string
-	StaticLinkMap   map[string]string
-	PrefixLinkMap   map[string]string
-	StaticPackages  []*Package
-	PreloadPackages []*Package
-	HeaderLinkMap   map[string]string
+	ReleaseMode             bool
+	Namespace               string
+	StaticLinkMap           map[string]string
+	PrefixLinkMap           map[string]string
+	StaticPackages          []*Package
+	PreloadPackages         []*Package
+	HeaderLinks             []*HeaderLink
+	PresignSigner           func(key string) (string, error)
+	MaxDecompressedSize     int64
+	MaxMetaDepth            int
+	Middlewares             []gin.HandlerFunc
+	StaticResponseMap       map[string]*StaticResponse
+	RateLimits              map[string]rate.Limit
+	DefaultRateLimit        rate.Limit
+	Logger                  Logger
+	CorrelationHeader       string
+	Tracer                  trace.Tracer
+	EmptyQueryPolicy        EmptyQueryPolicy
+	RequestIDHeader         string
+	CloseTunnelsOnShutdown  bool
+	ForwardHeaders          []string
+	APIGatewayV2            bool
+	RootHandler             RootMode
+	NotFoundHandler         gin.HandlerFunc
+	MethodNotAllowedHandler gin.HandlerFunc
+	AfterResponseHook       func(AfterResponseInfo)
+	AfterResponseWorkers    int
+	AfterResponseQueueSize  int
+	AfterResponseDrain      bool
+	FallbackPackage         *Package
+	DeepHealthCheck         bool
+	CompressionCodecs       []string
+	BodyLimits              *BodyLimits
+	PackageLimits           map[string]*BodyLimits
+	PanicHandler            func(c *gin.Context, recovered interface{})
+	PrependMiddlewares      []gin.HandlerFunc
+	SignedURLKeys           map[string]*rsa.PublicKey
+	SignedURLPrefixes       []string
+	SignedURLHost           string
+	SignedURLTrustedHops    int
+	TrailingSlash           TrailingSlashPolicy
+	BuildInfo               *BuildInfo
+	ReadyPath               string
+	Bulkheads               map[string]int
+	BulkheadTimeout         time.Duration
+	ResponseTransform       func(path string, body string) (string, error)
+	DebugPrefix             string
+	DebugHeaderKey          string
+	RequestValidator        func(path string, req string) error
+	RouteParams             map[string][]string
+	RouteParamsKey          string
+	Codec                   Codec
+	JSONDebug               bool
+	DebugCapture            bool
+	AllowedPackages         map[string]bool
+	VersionAliases          map[string]map[string]string
+	RoutesEndpoint          bool
+	Canaries                map[string]map[string]int
+	CanaryHeader            string
+	MaxHeaderCount          int
+	MaxHeaderBytes          int
+	RequestTransform        func(ctx context.Context, path string, req string) (string, error)
+	PreloadPolicy           PreloadPolicy
+	RawResponseTransform    func(ctx context.Context, path string, rsp string) (string, error)
+	PanicRecovery           bool
+	ConfigEndpoint          bool
 }
 
 func NewOptions(opts ...Option) *Options {
@@ -30,12 +197,37 @@ func NewOptions(opts ...Option) *Options {
 type Option func(*Options)
 
 var defaultOptions = &Options{
-	Namespace:       "",
-	StaticLinkMap:   map[string]string{},
-	PrefixLinkMap:   map[string]string{},
-	StaticPackages:  []*Package{},
-	PreloadPackages: []*Package{},
-	HeaderLinkMap:   map[string]string{},
+	Namespace:              "",
+	StaticLinkMap:          map[string]string{},
+	PrefixLinkMap:          map[string]string{},
+	StaticPackages:         []*Package{},
+	PreloadPackages:        []*Package{},
+	HeaderLinks:            []*HeaderLink{},
+	MaxDecompressedSize:    32 << 20,
+	MaxMetaDepth:           3,
+	Middlewares:            []gin.HandlerFunc{},
+	PrependMiddlewares:     []gin.HandlerFunc{},
+	StaticResponseMap:      map[string]*StaticResponse{},
+	RateLimits:             map[string]rate.Limit{},
+	Logger:                 &StdLogger{},
+	ForwardHeaders:         []string{},
+	RootHandler:            RootOK(),
+	AfterResponseWorkers:   4,
+	AfterResponseQueueSize: 1024,
+	CompressionCodecs:      []string{},
+	BodyLimits:             &BodyLimits{},
+	PackageLimits:          map[string]*BodyLimits{},
+	ReadyPath:              "/ready",
+	Bulkheads:              map[string]int{},
+	DebugPrefix:            "/_",
+	RouteParams:            map[string][]string{},
+	RouteParamsKey:         "__params__",
+	Codec:                  JSONCodec{},
+	DebugCapture:           true,
+	AllowedPackages:        map[string]bool{},
+	PanicRecovery:          true,
+	VersionAliases:         map[string]map[string]string{},
+	Canaries:               map[string]map[string]int{},
 }
 
 func (o *Options) init(opts ...Option) {
@@ -56,18 +248,37 @@ func WithNamespace(namespace string) Option {
 	}
 }
 
+// WithStaticLink links srcPath to dstPath, both are given a leading slash
+// and empty paths are ignored
 func WithStaticLink(srcPath, dstPath string) Option {
 	return func(o *Options) {
-		o.StaticLinkMap[srcPath] = dstPath
+		if srcPath == "" || dstPath == "" {
+			return
+		}
+		o.StaticLinkMap[normalizePath(srcPath)] = normalizePath(dstPath)
 	}
 }
 
+// WithPrefixLink links srcPrefix to dstPrefix, both are given a leading
+// slash, trailing slashes are trimmed and empty prefixes are ignored.
+// The source prefix only matches whole path segments.
 func WithPrefixLink(srcPrefix string, dstPrefix string) Option {
 	return func(o *Options) {
-		o.PrefixLinkMap[srcPrefix] = dstPrefix
+		if srcPrefix == "" || dstPrefix == "" {
+			return
+		}
+		o.PrefixLinkMap[normalizePrefix(srcPrefix)] = normalizePrefix(dstPrefix)
 	}
 }
 
+func normalizePath(path string) string {
+	return "/" + strings.TrimLeft(path, "/")
+}
+
+func normalizePrefix(prefix string) string {
+	return normalizePath(strings.TrimRight(prefix, "/"))
+}
+
 func WithStaticPackage(packageName, commit string, tunnel dynamic.Tunnel) Option {
 	return func(o *Options) {
 		o.StaticPackages = append(o.StaticPackages, &Package{
@@ -87,8 +298,532 @@ func WithPreloadPackage(packageName, commit string) Option {
 	}
 }
 
+// WithPreload preloads each of the packages as WithPreloadPackage does, the
+// packages are pulled one after another when the engine is constructed,
+// see WithPreloadPolicy for failures
+func WithPreload(specs []PackageSpec) Option {
+	return func(o *Options) {
+		for _, spec := range specs {
+			WithPreloadPackage(spec.Name, spec.Commit)(o)
+		}
+	}
+}
+
+func WithPreloadPolicy(policy PreloadPolicy) Option {
+	return func(o *Options) {
+		o.PreloadPolicy = policy
+	}
+}
+
+// WithHeaderLinkKey links requests carrying the header key to prefix.
+// Header links are matched in the order they are configured, so when a
+// request carries several linked headers the first configured one wins.
 func WithHeaderLinkKey(key string, prefix string) Option {
 	return func(o *Options) {
-		o.HeaderLinkMap[key] = prefix
+		for _, l := range o.HeaderLinks {
+			if l.Key == key {
+				l.Prefix = prefix
+				return
+			}
+		}
+		o.HeaderLinks = append(o.HeaderLinks, &HeaderLink{
+			Key:    key,
+			Prefix: prefix,
+		})
+	}
+}
+
+func WithPresignRedirect(signer func(key string) (string, error)) Option {
+	return func(o *Options) {
+		o.PresignSigner = signer
+	}
+}
+
+// WithMaxDecompressedSize bounds the size of a gzip request body once
+// decompressed, 32 MiB by default, zero means unlimited
+func WithMaxDecompressedSize(size int64) Option {
+	return func(o *Options) {
+		o.MaxDecompressedSize = size
+	}
+}
+
+func WithMaxMetaDepth(depth int) Option {
+	return func(o *Options) {
+		o.MaxMetaDepth = depth
+	}
+}
+
+// WithMiddleware appends middlewares which run after the built-in link
+// middlewares and before every route handler.
+//
+// Middlewares execute in this order: prepended middlewares, the header,
+// static and prefix links, the built-in request middlewares, appended
+// middlewares, then the route handler.
+func WithMiddleware(mw ...gin.HandlerFunc) Option {
+	return func(o *Options) {
+		o.Middlewares = append(o.Middlewares, mw...)
+	}
+}
+
+// WithStaticResponse serves a fixed response for path ahead of the gin router,
+// useful for cheap answers to paths like /robots.txt and /favicon.ico
+func WithStaticResponse(path string, status int, contentType string, body []byte) Option {
+	return func(o *Options) {
+		o.StaticResponseMap[path] = &StaticResponse{
+			Status:      status,
+			ContentType: contentType,
+			Body:        body,
+		}
+	}
+}
+
+// WithRateLimit limits the request rate of each package in the map,
+// requests beyond the limit are rejected with 429
+func WithRateLimit(perPackage map[string]rate.Limit) Option {
+	return func(o *Options) {
+		for packageName, limit := range perPackage {
+			o.RateLimits[packageName] = limit
+		}
+	}
+}
+
+// WithDefaultRateLimit limits the request rate of packages without
+// a dedicated limit, zero means unlimited
+func WithDefaultRateLimit(limit rate.Limit) Option {
+	return func(o *Options) {
+		o.DefaultRateLimit = limit
+	}
+}
+
+func WithLogger(l Logger) Option {
+	return func(o *Options) {
+		o.Logger = l
+	}
+}
+
+// WithCorrelationHeader seeds the correlation id from the named request
+// header, generating one when absent, and echoes it on the response
+func WithCorrelationHeader(name string) Option {
+	return func(o *Options) {
+		o.CorrelationHeader = name
+	}
+}
+
+func WithTracing(tracer trace.Tracer) Option {
+	return func(o *Options) {
+		o.Tracer = tracer
+	}
+}
+
+func WithEmptyQueryPolicy(policy EmptyQueryPolicy) Option {
+	return func(o *Options) {
+		o.EmptyQueryPolicy = policy
+	}
+}
+
+// WithRequestID passes through or generates a request id carried by the
+// header, X-Request-Id when header is empty
+func WithRequestID(header string) Option {
+	return func(o *Options) {
+		if header == "" {
+			header = "X-Request-Id"
+		}
+		o.RequestIDHeader = header
+	}
+}
+
+// WithCloseTunnelsOnShutdown closes all loaded tunnels after the server
+// is shut down by Close
+func WithCloseTunnelsOnShutdown() Option {
+	return func(o *Options) {
+		o.CloseTunnelsOnShutdown = true
+	}
+}
+
+// WithForwardHeaders forwards the named request headers into the request
+// meta, keyed by the lower snake case header name
+func WithForwardHeaders(names ...string) Option {
+	return func(o *Options) {
+		o.ForwardHeaders = append(o.ForwardHeaders, names...)
+	}
+}
+
+// WithAPIGatewayV2 fills the request meta from the API Gateway HTTP API
+// request context forwarded by the lambda adapter
+func WithAPIGatewayV2() Option {
+	return func(o *Options) {
+		o.APIGatewayV2 = true
+	}
+}
+
+// WithRootHandler decides how the root path is served, /health-check
+// always responds OK
+func WithRootHandler(mode RootMode) Option {
+	return func(o *Options) {
+		o.RootHandler = mode
+	}
+}
+
+// WithNotFoundHandler serves unmatched paths with handler, the status
+// defaults to 404 unless the handler writes another one
+func WithNotFoundHandler(handler gin.HandlerFunc) Option {
+	return func(o *Options) {
+		o.NotFoundHandler = handler
+	}
+}
+
+// WithMethodNotAllowedHandler serves unsupported methods with handler, the
+// status defaults to 405 and the Allow header is already set
+func WithMethodNotAllowedHandler(handler gin.HandlerFunc) Option {
+	return func(o *Options) {
+		o.MethodNotAllowedHandler = handler
+	}
+}
+
+// WithAfterResponse runs hook asynchronously after each response, hooks
+// are dropped rather than delaying requests when the workers fall behind
+func WithAfterResponse(hook func(AfterResponseInfo)) Option {
+	return func(o *Options) {
+		o.AfterResponseHook = hook
+	}
+}
+
+func WithAfterResponseWorkers(workers int, queueSize int) Option {
+	return func(o *Options) {
+		o.AfterResponseWorkers = workers
+		o.AfterResponseQueueSize = queueSize
+	}
+}
+
+// WithAfterResponseDrain runs the queued hooks on Stop instead of
+// discarding them
+func WithAfterResponseDrain() Option {
+	return func(o *Options) {
+		o.AfterResponseDrain = true
+	}
+}
+
+// WithFallbackPackage invokes the package with the full path as route
+// when the path doesn't resolve to a package
+func WithFallbackPackage(packageName, commit string) Option {
+	return func(o *Options) {
+		o.FallbackPackage = &Package{
+			Name:   packageName,
+			Commit: commit,
+		}
+	}
+}
+
+// WithDeepHealthCheck makes /health-check verify that static and preloaded
+// packages resolve and that their tunnels report healthy
+func WithDeepHealthCheck() Option {
+	return func(o *Options) {
+		o.DeepHealthCheck = true
+	}
+}
+
+// WithCompressionCodecs enables response compression with the codecs in
+// order of preference, unsupported codecs are ignored
+func WithCompressionCodecs(codecs []string) Option {
+	return func(o *Options) {
+		o.CompressionCodecs = o.CompressionCodecs[:0]
+		for _, codec := range codecs {
+			if _, ok := compressors[codec]; ok {
+				o.CompressionCodecs = append(o.CompressionCodecs, codec)
+			}
+		}
+	}
+}
+
+func WithBodyLimits(maxReqBytes, maxRespBytes int64) Option {
+	return func(o *Options) {
+		o.BodyLimits = &BodyLimits{
+			MaxRequestBytes:  maxReqBytes,
+			MaxResponseBytes: maxRespBytes,
+		}
+	}
+}
+
+// WithPackageLimits overrides the body limits for a package version, an
+// empty version applies to every version of the package
+func WithPackageLimits(packageName, commit string, maxReqBytes, maxRespBytes int64) Option {
+	return func(o *Options) {
+		o.PackageLimits[packageName+"@"+commit] = &BodyLimits{
+			MaxRequestBytes:  maxReqBytes,
+			MaxResponseBytes: maxRespBytes,
+		}
+	}
+}
+
+// WithPanicHandler is called with the recovered value when a handler
+// panics, a response written by it replaces the default 500 body. The
+// stack of the panic is available from the PanicStackContext value.
+func WithPanicHandler(handler func(c *gin.Context, recovered interface{})) Option {
+	return func(o *Options) {
+		o.PanicHandler = handler
+	}
+}
+
+// WithPrependMiddleware appends middlewares which run before the built-in
+// link middlewares, e.g. to rewrite the path before links are matched.
+// A link rewriting the path handles the request again, so prepended
+// middlewares run once more for the rewritten path.
+func WithPrependMiddleware(mw ...gin.HandlerFunc) Option {
+	return func(o *Options) {
+		o.PrependMiddlewares = append(o.PrependMiddlewares, mw...)
+	}
+}
+
+// WithSignedURLVerification requires requests under the prefixes to carry
+// a CloudFront signed url or signed cookies verified against the public
+// key of the key pair id. Without prefixes every package route is protected.
+func WithSignedURLVerification(keys map[string]*rsa.PublicKey, prefixes ...string) Option {
+	return func(o *Options) {
+		o.SignedURLKeys = keys
+		o.SignedURLPrefixes = prefixes
+	}
+}
+
+// WithSignedURLHost sets the host signed urls are issued for, e.g. the
+// CloudFront domain, when it differs from the host the engine receives
+func WithSignedURLHost(host string) Option {
+	return func(o *Options) {
+		o.SignedURLHost = host
+	}
+}
+
+// WithSignedURLTrustedHops checks the IpAddress condition of signed url
+// policies against the X-Forwarded-For entry hops from the right, e.g. 1
+// when CloudFront appends the viewer address. Without it the peer address
+// or the API Gateway source ip is used.
+func WithSignedURLTrustedHops(hops int) Option {
+	return func(o *Options) {
+		o.SignedURLTrustedHops = hops
+	}
+}
+
+// WithRedirectTrailingSlash redirects every path ending with a slash,
+// including the catch-all package routes, to the path without it.
+// Disabling it also turns off the gin trailing slash redirect.
+func WithRedirectTrailingSlash(enabled bool) Option {
+	return func(o *Options) {
+		if enabled {
+			o.TrailingSlash = TrailingSlashRedirect
+		} else {
+			o.TrailingSlash = TrailingSlashNone
+		}
+	}
+}
+
+// WithRewriteTrailingSlash routes paths ending with a slash internally as
+// the path without it, without a redirect
+func WithRewriteTrailingSlash() Option {
+	return func(o *Options) {
+		o.TrailingSlash = TrailingSlashRewrite
+	}
+}
+
+// WithBuildInfo installs the /version route and appends the compact build
+// info to the /health-check response
+func WithBuildInfo(info BuildInfo) Option {
+	return func(o *Options) {
+		o.BuildInfo = &info
+	}
+}
+
+// WithReadyPath moves the readiness probe, an empty path disables it
+func WithReadyPath(path string) Option {
+	return func(o *Options) {
+		if path == "" {
+			o.ReadyPath = ""
+			return
+		}
+		o.ReadyPath = normalizePath(path)
+	}
+}
+
+// WithBulkhead caps the concurrent invocations of each package, a request
+// to a saturated package is rejected with 503
+func WithBulkhead(perPackage map[string]int) Option {
+	return func(o *Options) {
+		for packageName, n := range perPackage {
+			o.Bulkheads[packageName] = n
+		}
+	}
+}
+
+// WithBulkheadTimeout makes a request to a saturated package wait up to
+// timeout for a slot before it is rejected
+func WithBulkheadTimeout(timeout time.Duration) Option {
+	return func(o *Options) {
+		o.BulkheadTimeout = timeout
+	}
+}
+
+// WithResponseTransform rewrites every successful API response body after
+// the response meta is stripped, redirects and errors bypass it
+func WithResponseTransform(transform func(path string, body string) (string, error)) Option {
+	return func(o *Options) {
+		o.ResponseTransform = transform
+	}
+}
+
+// WithDebugPrefix serves the debug routes under prefix instead of /_
+func WithDebugPrefix(prefix string) Option {
+	return func(o *Options) {
+		if prefix = normalizePrefix(prefix); prefix == "/" {
+			return
+		}
+		o.DebugPrefix = prefix
+	}
+}
+
+// WithDebugHeader turns on debug mode for any route when the request
+// carries header with a true value, e.g. X-Debug: 1
+func WithDebugHeader(header string) Option {
+	return func(o *Options) {
+		o.DebugHeaderKey = http.CanonicalHeaderKey(header)
+	}
+}
+
+// WithRequestValidator checks every API and WAPI request before it is
+// handled, a request failing validation is rejected with 400
+func WithRequestValidator(validator func(path string, req string) error) Option {
+	return func(o *Options) {
+		o.RequestValidator = validator
+	}
+}
+
+// WithRouteParams registers route patterns of the package such as
+// /users/:id, the params of the first matching pattern are injected into
+// JSON object requests
+func WithRouteParams(packageName string, patterns ...string) Option {
+	return func(o *Options) {
+		o.RouteParams[packageName] = append(o.RouteParams[packageName], patterns...)
+	}
+}
+
+// WithRouteParamsKey sets the request key holding the route params,
+// __params__ by default
+func WithRouteParamsKey(key string) Option {
+	return func(o *Options) {
+		if key != "" {
+			o.RouteParamsKey = key
+		}
+	}
+}
+
+// WithCodec replaces the JSON codec used to assemble requests and take
+// apart response meta, e.g. with MsgPackCodec
+func WithCodec(codec Codec) Option {
+	return func(o *Options) {
+		if codec != nil {
+			o.Codec = codec
+		}
+	}
+}
+
+// WithJSONDebug formats the debug output as a JSON object instead of text
+func WithJSONDebug() Option {
+	return func(o *Options) {
+		o.JSONDebug = true
+	}
+}
+
+// WithDebugCapture decides whether debug mode captures the output of
+// tunnels implementing DebugTunnel, each request gets writers of its own
+func WithDebugCapture(capture bool) Option {
+	return func(o *Options) {
+		o.DebugCapture = capture
+	}
+}
+
+// WithAllowedPackages only lets the listed packages be invoked, other
+// packages are rejected with 403 even when they are registered
+func WithAllowedPackages(names ...string) Option {
+	return func(o *Options) {
+		for _, name := range names {
+			o.AllowedPackages[name] = true
+		}
+	}
+}
+
+// WithVersionAlias resolves alias of the package, e.g. latest, to a
+// concrete version on this engine only, it takes precedence over the
+// process wide aliases of SetAlias
+func WithVersionAlias(packageName string, alias string, version string) Option {
+	return func(o *Options) {
+		if _, ok := o.VersionAliases[packageName]; !ok {
+			o.VersionAliases[packageName] = map[string]string{}
+		}
+		o.VersionAliases[packageName][alias] = version
+	}
+}
+
+// WithRoutesEndpoint installs /_routes listing the routes of the engine
+// and the loaded tunnels
+func WithRoutesEndpoint() Option {
+	return func(o *Options) {
+		o.RoutesEndpoint = true
+	}
+}
+
+// WithCanary splits the requests to the canary version of the package
+// between the weighted versions, e.g. {"v1": 90, "v2": 10}
+func WithCanary(packageName string, weights map[string]int) Option {
+	return func(o *Options) {
+		o.Canaries[packageName] = weights
+	}
+}
+
+// WithCanaryHeader makes the canary choice sticky to the value of header,
+// a value naming one of the weighted versions selects it directly
+func WithCanaryHeader(header string) Option {
+	return func(o *Options) {
+		o.CanaryHeader = header
+	}
+}
+
+// WithMaxHeaders rejects requests with more than count header fields or
+// more than totalBytes of header names and values with 431, zero means
+// unlimited
+func WithMaxHeaders(count int, totalBytes int) Option {
+	return func(o *Options) {
+		o.MaxHeaderCount = count
+		o.MaxHeaderBytes = totalBytes
+	}
+}
+
+// WithRequestTransform rewrites every API and WAPI request right before it
+// is handled, after the request meta is injected, an error aborts the
+// request
+func WithRequestTransform(transform func(ctx context.Context, path string, req string) (string, error)) Option {
+	return func(o *Options) {
+		o.RequestTransform = transform
+	}
+}
+
+// WithRawResponseTransform rewrites the API response as the tunnel returned
+// it, before the response meta is extracted, so the transform sees and may
+// produce __meta__. Unlike WithResponseTransform it also runs for redirects.
+func WithRawResponseTransform(transform func(ctx context.Context, path string, rsp string) (string, error)) Option {
+	return func(o *Options) {
+		o.RawResponseTransform = transform
+	}
+}
+
+// WithPanicRecovery decides whether handler panics are recovered into a
+// 500, disabling it lets the panic propagate with its real stack
+func WithPanicRecovery(enabled bool) Option {
+	return func(o *Options) {
+		o.PanicRecovery = enabled
+	}
+}
+
+// WithConfigEndpoint installs /_/config dumping the effective configuration,
+// it exposes package and routing details so keep it off public deployments
+func WithConfigEndpoint() Option {
+	return func(o *Options) {
+		o.ConfigEndpoint = true
 	}
 }
@@ -0,0 +1,36 @@
+package httpserver
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestLinkNormalization(t *testing.T) {
+	pkg := testPackage(t)
+	routeTunnel := funcTunnel(func(route string, req string) string { return route })
+	e := NewEngine(
+		WithStaticPackage(pkg, "v1", routeTunnel),
+		WithStaticLink("old", "api/"+pkg+"/v1/new"),
+		WithPrefixLink("v1/", "api/"+pkg+"/v1/"),
+		WithStaticLink("", "/ignored"),
+	)
+
+	for _, tc := range []struct {
+		target string
+		code   int
+		body   string
+	}{
+		{"/old", http.StatusOK, "/new"},
+		{"/v1/users", http.StatusOK, "/users"},
+		{"/v1", http.StatusOK, "/"},
+		{"/v10/users", http.StatusNotFound, ""},
+	} {
+		w := serve(e, http.MethodGet, tc.target, "", nil)
+		if w.Code != tc.code || tc.body != "" && w.Body.String() != tc.body {
+			t.Fatalf("%s: got %d %s", tc.target, w.Code, w.Body.String())
+		}
+	}
+	if _, ok := e.StaticLinkMap["/"]; ok {
+		t.Fatal("empty static link registered")
+	}
+}
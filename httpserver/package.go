@@ -1,7 +1,8 @@
 package httpserver
 
 import (
-	"log"
+	"fmt"
+	"strings"
 
 	"github.com/aura-studio/dynamic"
 )
@@ -13,9 +14,133 @@ func (e *Engine) InstallPackages() {
 		dynamic.RegisterPackage(p.Name, p.Commit, p.Tunnel)
 	}
 
+	e.preloadPackages()
+}
+
+// preloadPackages loads the preload packages one after another, dynamic
+// pulls packages under a process wide lock so loading them in parallel
+// would gain nothing. Failures are logged and make the engine panic under
+// PreloadFailFast.
+func (e *Engine) preloadPackages() {
+	var errs []error
 	for _, p := range e.PreloadPackages {
-		if _, err := dynamic.GetPackage(p.Name, p.Commit); err != nil {
-			log.Printf("preload package %s@%s failed: %v", p.Name, p.Commit, err)
+		tunnel, err := dynamic.GetPackage(p.Name, p.Commit)
+		if err == nil && tunnel == nil {
+			err = fmt.Errorf("not found")
+		}
+		if err != nil {
+			e.Logger.Error("preload package failed", "package", p.Name, "commit", p.Commit, "error", err)
+			errs = append(errs, fmt.Errorf("preload package %s@%s: %w", p.Name, p.Commit, err))
+		}
+	}
+
+	if e.PreloadPolicy == PreloadFailFast && len(errs) > 0 {
+		panic(errs[0])
+	}
+}
+
+// CloseTunnels closes every loaded tunnel, a panicking tunnel is logged
+// and does not prevent the others from being closed. The packages the
+// engine registered or resolved are closed through dynamic so a later
+// lookup loads them again instead of returning a closed tunnel.
+func (e *Engine) CloseTunnels() {
+	for _, p := range e.knownPackages() {
+		e.closePackage(p)
+	}
+
+	var names []string
+	dynamic.RangeTunnel(func(name string, _ dynamic.Tunnel) bool {
+		names = append(names, name)
+		return true
+	})
+
+	for _, name := range names {
+		e.closeTunnel(name)
+	}
+}
+
+// packageKey is a package version the engine registered or resolved
+type packageKey struct {
+	name   string
+	commit string
+}
+
+// addPackage records a resolved package version, along with latest which
+// dynamic falls back to for missing versions
+func (e *Engine) addPackage(packageName string, commit string) {
+	e.packages.Store(packageKey{packageName, commit}, true)
+	e.packages.Store(packageKey{packageName, dynamic.Latest}, true)
+}
+
+func (e *Engine) knownPackages() []packageKey {
+	for _, packages := range [][]*Package{e.StaticPackages, e.PreloadPackages} {
+		for _, p := range packages {
+			e.addPackage(p.Name, p.Commit)
 		}
 	}
+	if e.FallbackPackage != nil {
+		e.addPackage(e.FallbackPackage.Name, e.FallbackPackage.Commit)
+	}
+
+	var keys []packageKey
+	e.packages.Range(func(k, _ interface{}) bool {
+		keys = append(keys, k.(packageKey))
+		return true
+	})
+	return keys
+}
+
+func (e *Engine) closePackage(p packageKey) {
+	defer func() {
+		if v := recover(); v != nil {
+			e.Logger.Error("close package panic", "package", p.name, "commit", p.commit, "panic", v)
+		}
+	}()
+
+	name := tunnelName(e.Namespace, p.name, p.commit)
+	if !isTunnelLoaded(name) {
+		// only drops a not found entry
+		dynamic.ClosePackage(p.name, p.commit)
+		return
+	}
+
+	// ClosePackage closes the tunnel only when the package maps to it, and
+	// leaves it among the loaded tunnels, where a stand-in replaces it so it
+	// is dropped without being closed twice
+	defer func() {
+		dynamic.RegisterTunnel(name, &dynamic.Template{})
+		dynamic.CloseTunnel(name)
+	}()
+	dynamic.GetPackage(p.name, p.commit)
+	dynamic.ClosePackage(p.name, p.commit)
+}
+
+// tunnelName names the tunnel of a package version the way dynamic does
+func tunnelName(namespace string, packageName string, commit string) string {
+	name := strings.Join([]string{packageName, commit}, "_")
+	if namespace != "" {
+		name = strings.Join([]string{namespace, name}, "_")
+	}
+	return name
+}
+
+func isTunnelLoaded(name string) bool {
+	var loaded bool
+	dynamic.RangeTunnel(func(n string, _ dynamic.Tunnel) bool {
+		loaded = n == name
+		return !loaded
+	})
+	return loaded
+}
+
+func (e *Engine) closeTunnel(name string) {
+	defer func() {
+		if v := recover(); v != nil {
+			e.Logger.Error("close tunnel panic", "tunnel", name, "panic", v)
+		}
+	}()
+
+	if err := dynamic.CloseTunnel(name); err != nil {
+		e.Logger.Error("close tunnel failed", "tunnel", name, "error", err)
+	}
 }
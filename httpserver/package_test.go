@@ -0,0 +1,109 @@
+package httpserver
+
+import (
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/aura-studio/dynamic"
+)
+
+func TestPreload(t *testing.T) {
+	pkg := testPackage(t)
+	// a tunnel known to dynamic, as a pulled plugin is, but not registered
+	// as a package
+	dynamic.RegisterTunnel(pkg+"_v1", funcTunnel(func(route string, req string) string { return "preloaded" }))
+
+	e := NewEngine(WithPreload([]PackageSpec{{Name: pkg, Commit: "v1"}}), WithPreloadPolicy(PreloadFailFast))
+
+	if w := serve(e, http.MethodGet, "/ready", "", nil); w.Code != http.StatusOK {
+		t.Fatalf("ready: got %d %s", w.Code, w.Body.String())
+	}
+	if w := serve(e, http.MethodGet, "/api/"+pkg+"/v1/route", "", nil); w.Code != http.StatusOK || w.Body.String() != "preloaded" {
+		t.Fatalf("invoke: got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestPreloadFailFast(t *testing.T) {
+	pkg := testPackage(t)
+
+	defer func() {
+		v := recover()
+		err, ok := v.(error)
+		if !ok || !strings.Contains(err.Error(), "preload package "+pkg+"@v1") {
+			t.Fatalf("got panic %v", v)
+		}
+	}()
+
+	NewEngine(WithPreload([]PackageSpec{{Name: pkg, Commit: "v1"}}), WithPreloadPolicy(PreloadFailFast), WithLogger(&testLogger{}))
+}
+
+func TestPreloadLog(t *testing.T) {
+	pkg := testPackage(t)
+	logger := &testLogger{}
+
+	e := NewEngine(WithPreload([]PackageSpec{{Name: pkg, Commit: "v1"}}), WithLogger(logger))
+
+	if !logger.logged("ERROR preload package failed") {
+		t.Error("preload failure not logged")
+	}
+	if w := serve(e, http.MethodGet, "/ready", "", nil); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("ready: got %d", w.Code)
+	}
+}
+
+// closeTunnel records how often it is closed and may panic when closed
+type closeTunnel struct {
+	closed int32
+	panics bool
+}
+
+func (t *closeTunnel) Init() {}
+
+func (t *closeTunnel) Close() {
+	atomic.AddInt32(&t.closed, 1)
+	if t.panics {
+		panic("close failed")
+	}
+}
+
+func (t *closeTunnel) Invoke(route string, req string) string { return "ok" }
+
+func TestCloseTunnels(t *testing.T) {
+	pkg := testPackage(t)
+	static := &closeTunnel{}
+	panicking := &closeTunnel{panics: true}
+	other := &closeTunnel{}
+	dynamic.RegisterTunnel(pkg+"-other_v1", other)
+
+	logger := &testLogger{}
+	e := NewEngine(
+		WithStaticPackage(pkg, "v1", static),
+		WithStaticPackage(pkg+"-panicking", "v1", panicking),
+		WithCloseTunnelsOnShutdown(),
+		WithLogger(logger),
+	)
+	if w := serve(e, http.MethodGet, "/api/"+pkg+"/v1/route", "", nil); w.Code != http.StatusOK {
+		t.Fatalf("got %d %s", w.Code, w.Body.String())
+	}
+
+	e.Stop()
+
+	for name, tunnel := range map[string]*closeTunnel{"static": static, "panicking": panicking, "other": other} {
+		if closed := atomic.LoadInt32(&tunnel.closed); closed != 1 {
+			t.Errorf("%s tunnel closed %d times", name, closed)
+		}
+	}
+	if !logger.logged("ERROR close package panic") {
+		t.Error("close panic not logged")
+	}
+
+	// dynamic forgot the closed tunnel rather than handing it out again
+	if tunnel, err := dynamic.GetPackage(pkg, "v1"); err == nil && tunnel == dynamic.Tunnel(static) {
+		t.Error("closed tunnel returned by dynamic")
+	}
+	if w := serve(e, http.MethodGet, "/api/"+pkg+"/v1/route", "", nil); w.Code == http.StatusOK {
+		t.Errorf("closed package still invoked")
+	}
+}
@@ -0,0 +1,53 @@
+package httpserver
+
+import "strings"
+
+// matchRoute matches route against a pattern such as /users/:id, a final
+// *name segment captures the rest of the route
+func matchRoute(pattern string, route string) (map[string]string, bool) {
+	patternParts := strings.Split(strings.Trim(pattern, "/"), "/")
+	routeParts := strings.Split(strings.Trim(route, "/"), "/")
+
+	params := map[string]string{}
+	for i, part := range patternParts {
+		if strings.HasPrefix(part, "*") && i == len(patternParts)-1 {
+			if i >= len(routeParts) {
+				return nil, false
+			}
+			params[part[1:]] = strings.Join(routeParts[i:], "/")
+			return params, true
+		}
+		if i >= len(routeParts) {
+			return nil, false
+		}
+		if strings.HasPrefix(part, ":") {
+			if routeParts[i] == "" {
+				return nil, false
+			}
+			params[part[1:]] = routeParts[i]
+		} else if part != routeParts[i] {
+			return nil, false
+		}
+	}
+
+	if len(patternParts) != len(routeParts) {
+		return nil, false
+	}
+	return params, true
+}
+
+// routeParams returns the params of the first pattern of the package that
+// matches the route
+func (e *Engine) routeParams(path string) (map[string]string, bool) {
+	packageName, _, route, err := parsePath(path)
+	if err != nil {
+		return nil, false
+	}
+
+	for _, pattern := range e.RouteParams[packageName] {
+		if params, ok := matchRoute(pattern, route); ok {
+			return params, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,62 @@
+package httpserver
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestMatchRoute(t *testing.T) {
+	for _, tc := range []struct {
+		pattern string
+		route   string
+		params  map[string]string
+	}{
+		{"/users/:id", "/users/42", map[string]string{"id": "42"}},
+		{"/users/:id/posts/:post", "/users/42/posts/7", map[string]string{"id": "42", "post": "7"}},
+		{"/files/*path", "/files/a/b.txt", map[string]string{"path": "a/b.txt"}},
+		{"/users/:id", "/users", nil},
+		{"/users/:id", "/users/42/posts", nil},
+		{"/users/:id", "/groups/42", nil},
+		{"/files/*path", "/files", nil},
+	} {
+		params, ok := matchRoute(tc.pattern, tc.route)
+		if ok != (tc.params != nil) || len(params) != len(tc.params) {
+			t.Fatalf("%s %s: got %v %t", tc.pattern, tc.route, params, ok)
+		}
+		for k, v := range tc.params {
+			if params[k] != v {
+				t.Fatalf("%s %s: got %v", tc.pattern, tc.route, params)
+			}
+		}
+	}
+}
+
+func TestRouteParams(t *testing.T) {
+	pkg := testPackage(t)
+	e := NewEngine(
+		WithStaticPackage(pkg, "v1", echoTunnel),
+		WithRouteParams(pkg, "/users/:id", "/users/:id/posts/:post"),
+	)
+
+	w := serve(e, http.MethodPost, "/api/"+pkg+"/v1/users/42/posts/7", `{"title":"x"}`, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d %s", w.Code, w.Body.String())
+	}
+	body := w.Body.String()
+	if gjson.Get(body, "__params__.id").String() != "42" || gjson.Get(body, "__params__.post").String() != "7" || gjson.Get(body, "title").String() != "x" {
+		t.Fatalf("got %s", body)
+	}
+
+	w = serve(e, http.MethodPost, "/api/"+pkg+"/v1/groups/42", `{"title":"x"}`, nil)
+	if gjson.Get(w.Body.String(), "__params__").Exists() {
+		t.Fatalf("unmatched: got %s", w.Body.String())
+	}
+
+	e = NewEngine(WithStaticPackage(pkg, "v1", echoTunnel), WithRouteParams(pkg, "/users/:id"), WithRouteParamsKey("params"))
+	w = serve(e, http.MethodGet, "/api/"+pkg+"/v1/users/42", "", nil)
+	if gjson.Get(w.Body.String(), "params.id").String() != "42" {
+		t.Fatalf("custom key: got %s", w.Body.String())
+	}
+}
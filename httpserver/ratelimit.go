@@ -0,0 +1,77 @@
+package httpserver
+
+import (
+	"math"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+// rateLimiter holds one token bucket per package, shared by all requests
+type rateLimiter struct {
+	mu           sync.Mutex
+	limits       map[string]rate.Limit
+	defaultLimit rate.Limit
+	limiters     map[string]*rate.Limiter
+}
+
+func newRateLimiter(limits map[string]rate.Limit, defaultLimit rate.Limit) *rateLimiter {
+	return &rateLimiter{
+		limits:       limits,
+		defaultLimit: defaultLimit,
+		limiters:     map[string]*rate.Limiter{},
+	}
+}
+
+// Allow reports whether a request to the package may proceed, known tells
+// whether the package passes the allowlist and resolves, only those get a
+// bucket of the default limit so unknown names can't grow the limiters
+func (r *rateLimiter) Allow(packageName string, known func() bool) bool {
+	if len(r.limits) == 0 && !limited(r.defaultLimit) {
+		return true
+	}
+
+	limiter := r.getLimiter(packageName, known)
+	if limiter == nil {
+		return true
+	}
+
+	return limiter.Allow()
+}
+
+func (r *rateLimiter) getLimiter(packageName string, known func() bool) *rate.Limiter {
+	r.mu.Lock()
+	limiter, ok := r.limiters[packageName]
+	r.mu.Unlock()
+	if ok {
+		return limiter
+	}
+
+	limit, ok := r.limits[packageName]
+	if !ok {
+		// resolving may be slow, so it runs outside of the lock
+		if !limited(r.defaultLimit) || !known() {
+			return nil
+		}
+		limit = r.defaultLimit
+	}
+	if !limited(limit) {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if limiter, ok := r.limiters[packageName]; ok {
+		return limiter
+	}
+	limiter = rate.NewLimiter(limit, int(math.Max(1, math.Ceil(float64(limit)))))
+	r.limiters[packageName] = limiter
+
+	return limiter
+}
+
+// limited reports whether limit restricts anything, zero means unlimited
+func limited(limit rate.Limit) bool {
+	return limit > 0 && limit != rate.Inf
+}
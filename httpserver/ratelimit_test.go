@@ -0,0 +1,61 @@
+package httpserver
+
+import (
+	"net/http"
+	"testing"
+
+	"golang.org/x/time/rate"
+)
+
+func TestRateLimit(t *testing.T) {
+	pkg := testPackage(t)
+	e := NewEngine(
+		WithStaticPackage(pkg, "v1", versionTunnel("v1")),
+		WithRateLimit(map[string]rate.Limit{pkg: 1}),
+	)
+
+	var ok, limited int
+	for i := 0; i < 5; i++ {
+		switch w := serve(e, http.MethodGet, "/api/"+pkg+"/v1/route", "", nil); w.Code {
+		case http.StatusOK:
+			ok++
+		case http.StatusTooManyRequests:
+			limited++
+		default:
+			t.Fatalf("got %d %s", w.Code, w.Body.String())
+		}
+	}
+	if ok != 1 || limited != 4 {
+		t.Fatalf("got %d ok and %d rejected, want 1 and 4", ok, limited)
+	}
+
+	if w := serve(e, http.MethodGet, "/wapi/"+pkg+"/v1/route", "", nil); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("wapi: got %d", w.Code)
+	}
+}
+
+func TestDefaultRateLimit(t *testing.T) {
+	pkg := testPackage(t)
+	e := NewEngine(
+		WithStaticPackage(pkg, "v1", versionTunnel("v1")),
+		WithDefaultRateLimit(1),
+	)
+
+	codes := []int{
+		serve(e, http.MethodGet, "/api/"+pkg+"/v1/route", "", nil).Code,
+		serve(e, http.MethodGet, "/api/"+pkg+"/v1/route", "", nil).Code,
+	}
+	if codes[0] != http.StatusOK || codes[1] != http.StatusTooManyRequests {
+		t.Fatalf("got %v", codes)
+	}
+
+	// unknown packages fail on their own and get no bucket
+	for i := 0; i < 3; i++ {
+		if w := serve(e, http.MethodGet, "/api/"+pkg+"-missing/v1/route", "", nil); w.Code == http.StatusTooManyRequests {
+			t.Fatal("unknown package rate limited")
+		}
+	}
+	if n := len(e.rateLimiter.limiters); n != 1 {
+		t.Fatalf("got %d limiters, want 1", n)
+	}
+}
@@ -0,0 +1,52 @@
+package httpserver
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/aura-studio/dynamic"
+	"github.com/gin-gonic/gin"
+)
+
+// RouteInfo is a path served by the engine with the methods it accepts
+type RouteInfo struct {
+	Path    string   `json:"path"`
+	Methods []string `json:"methods"`
+}
+
+// RouteList groups the registered routes by path, unlike gin's Routes which
+// lists every method separately
+func (e *Engine) RouteList() []RouteInfo {
+	var routes []RouteInfo
+	index := map[string]int{}
+	for _, r := range e.Engine.Routes() {
+		i, ok := index[r.Path]
+		if !ok {
+			i = len(routes)
+			index[r.Path] = i
+			routes = append(routes, RouteInfo{Path: r.Path})
+		}
+		routes[i].Methods = append(routes[i].Methods, r.Method)
+	}
+
+	sort.Slice(routes, func(i, j int) bool {
+		return routes[i].Path < routes[j].Path
+	})
+	return routes
+}
+
+// ListRoutes reports the routes and the loaded tunnels
+func (e *Engine) ListRoutes(c *gin.Context) {
+	tunnels := []string{}
+	dynamic.RangeTunnel(func(name string, _ dynamic.Tunnel) bool {
+		tunnels = append(tunnels, name)
+		return true
+	})
+	sort.Strings(tunnels)
+
+	c.JSON(http.StatusOK, map[string]interface{}{
+		"routes":  e.RouteList(),
+		"tunnels": tunnels,
+	})
+	c.Abort()
+}
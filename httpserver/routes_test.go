@@ -0,0 +1,69 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/aura-studio/dynamic"
+)
+
+func TestRouteList(t *testing.T) {
+	routes := map[string][]string{}
+	for _, r := range NewEngine().RouteList() {
+		routes[r.Path] = r.Methods
+	}
+
+	for _, path := range []string{"/", "/health-check", "/ready", "/api/*path", "/wapi/*path", "/_/api/*path", "/_/wapi/*path"} {
+		if len(routes[path]) != len(methods) {
+			t.Errorf("%s: methods %v", path, routes[path])
+		}
+	}
+	for _, path := range []string{"/_routes", "/_/config", "/version"} {
+		if _, ok := routes[path]; ok {
+			t.Errorf("%s listed without its option", path)
+		}
+	}
+}
+
+func TestRoutesEndpoint(t *testing.T) {
+	pkg := testPackage(t)
+	e := NewEngine(WithRoutesEndpoint(), WithStaticPackage(pkg, "v1", versionTunnel("v1")))
+
+	list := func() (paths []string, tunnels []string) {
+		w := serve(e, http.MethodGet, "/_routes", "", nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("got %d", w.Code)
+		}
+		var output struct {
+			Routes  []RouteInfo `json:"routes"`
+			Tunnels []string    `json:"tunnels"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &output); err != nil {
+			t.Fatal(err)
+		}
+		for _, r := range output.Routes {
+			paths = append(paths, r.Path)
+		}
+		return paths, output.Tunnels
+	}
+	hasSuffix := func(strs []string, suffix string) bool {
+		for _, s := range strs {
+			if strings.HasSuffix(s, suffix) {
+				return true
+			}
+		}
+		return false
+	}
+
+	paths, tunnels := list()
+	if !hasSuffix(paths, "/_routes") || !hasSuffix(tunnels, pkg+"_v1") || hasSuffix(tunnels, pkg+"_v2") {
+		t.Fatalf("got %v %v", paths, tunnels)
+	}
+
+	dynamic.RegisterPackage(pkg, "v2", versionTunnel("v2"))
+	if _, tunnels := list(); !hasSuffix(tunnels, pkg+"_v2") {
+		t.Fatalf("registered tunnel missing from %v", tunnels)
+	}
+}
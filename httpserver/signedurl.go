@@ -0,0 +1,232 @@
+package httpserver
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+var cloudFrontEncoding = strings.NewReplacer("-", "+", "_", "=", "~", "/")
+
+type cloudFrontPolicy struct {
+	Statement []struct {
+		Resource  string `json:"Resource"`
+		Condition struct {
+			DateLessThan struct {
+				EpochTime int64 `json:"AWS:EpochTime"`
+			} `json:"DateLessThan"`
+			DateGreaterThan *struct {
+				EpochTime int64 `json:"AWS:EpochTime"`
+			} `json:"DateGreaterThan"`
+			IPAddress *struct {
+				SourceIP string `json:"AWS:SourceIp"`
+			} `json:"IpAddress"`
+		} `json:"Condition"`
+	} `json:"Statement"`
+}
+
+// SignedURL verifies CloudFront style signed URLs or signed cookies on the
+// protected prefixes and rejects requests failing verification with 403
+func (e *Engine) SignedURL(c *gin.Context) {
+	if len(e.SignedURLKeys) == 0 || !e.isSignedURLProtected(c.Request.URL.Path) {
+		return
+	}
+
+	if err := e.verifySignedURL(c.Request, time.Now()); err != nil {
+		c.String(http.StatusForbidden, "403 forbidden: %v", err)
+		c.Abort()
+		return
+	}
+}
+
+func (e *Engine) isSignedURLProtected(path string) bool {
+	prefixes := e.SignedURLPrefixes
+	if len(prefixes) == 0 {
+		prefixes = []string{"/api/", "/wapi/", "/sse/", e.DebugPrefix + "/api/", e.DebugPrefix + "/wapi/"}
+	}
+
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *Engine) verifySignedURL(r *http.Request, now time.Time) error {
+	policy, signature, keyPairID, expires := signedURLParams(r)
+	if signature == "" || keyPairID == "" {
+		return errors.New("missing signature")
+	}
+
+	key, ok := e.SignedURLKeys[keyPairID]
+	if !ok {
+		return fmt.Errorf("unknown key pair %s", keyPairID)
+	}
+
+	var policyBytes []byte
+	if policy != "" {
+		b, err := base64.StdEncoding.DecodeString(cloudFrontEncoding.Replace(policy))
+		if err != nil {
+			return errors.New("malformed policy")
+		}
+		policyBytes = b
+	} else if expires != "" {
+		policyBytes = []byte(fmt.Sprintf(`{"Statement":[{"Resource":"%s","Condition":{"DateLessThan":{"AWS:EpochTime":%s}}}]}`, e.signedURLResource(r), expires))
+	} else {
+		return errors.New("missing policy")
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(cloudFrontEncoding.Replace(signature))
+	if err != nil {
+		return errors.New("malformed signature")
+	}
+	hashed := sha1.Sum(policyBytes)
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA1, hashed[:], sig); err != nil {
+		return errors.New("invalid signature")
+	}
+
+	// unknown fields are conditions which can't be enforced, so the policy
+	// is rejected rather than verified partially
+	var p cloudFrontPolicy
+	decoder := json.NewDecoder(bytes.NewReader(policyBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&p); err != nil || len(p.Statement) != 1 {
+		return errors.New("malformed or unsupported policy")
+	}
+	statement := p.Statement[0]
+	if now.Unix() >= statement.Condition.DateLessThan.EpochTime {
+		return errors.New("policy expired")
+	}
+	if statement.Condition.DateGreaterThan != nil && now.Unix() <= statement.Condition.DateGreaterThan.EpochTime {
+		return errors.New("policy not yet valid")
+	}
+	if statement.Condition.IPAddress != nil {
+		_, ipNet, err := net.ParseCIDR(statement.Condition.IPAddress.SourceIP)
+		if err != nil {
+			return errors.New("malformed source ip")
+		}
+		if ip := net.ParseIP(e.signedURLClientIP(r)); ip == nil || !ipNet.Contains(ip) {
+			return errors.New("source ip mismatch")
+		}
+	}
+	if statement.Resource != "" && !matchResource(statement.Resource, e.signedURLResource(r)) {
+		return errors.New("resource mismatch")
+	}
+
+	return nil
+}
+
+// signedURLClientIP returns the viewer address. X-Forwarded-For is only
+// trusted for the configured number of hops counted from the right, the
+// entries before are sent by the client and may be forged.
+func (e *Engine) signedURLClientIP(r *http.Request) string {
+	if e.SignedURLTrustedHops > 0 {
+		var hops []string
+		for _, forwardedFor := range r.Header.Values("X-Forwarded-For") {
+			hops = append(hops, strings.Split(forwardedFor, ",")...)
+		}
+		if len(hops) < e.SignedURLTrustedHops {
+			return ""
+		}
+		return strings.TrimSpace(hops[len(hops)-e.SignedURLTrustedHops])
+	}
+
+	if e.APIGatewayV2 {
+		if sourceIP := gjson.Get(r.Header.Get("X-Amzn-Request-Context"), "http.sourceIp"); sourceIP.Exists() {
+			return sourceIP.String()
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// signedURLParams reads the signing params from the query, falling back
+// to the CloudFront signed cookies
+func signedURLParams(r *http.Request) (policy, signature, keyPairID, expires string) {
+	query := r.URL.Query()
+	policy = query.Get("Policy")
+	signature = query.Get("Signature")
+	keyPairID = query.Get("Key-Pair-Id")
+	expires = query.Get("Expires")
+	if signature != "" {
+		return
+	}
+
+	if cookie, err := r.Cookie("CloudFront-Policy"); err == nil {
+		policy = cookie.Value
+	}
+	if cookie, err := r.Cookie("CloudFront-Signature"); err == nil {
+		signature = cookie.Value
+	}
+	if cookie, err := r.Cookie("CloudFront-Key-Pair-Id"); err == nil {
+		keyPairID = cookie.Value
+	}
+	return
+}
+
+// signedURLResource rebuilds the url the client requested without the
+// signing params. The request uri is used as links rewrite the path, and
+// the host is the configured signing host, e.g. the CloudFront domain.
+func (e *Engine) signedURLResource(r *http.Request) string {
+	scheme := r.Header.Get("X-Forwarded-Proto")
+	if scheme == "" {
+		scheme = "https"
+	}
+
+	host := e.SignedURLHost
+	if host == "" {
+		host = r.Header.Get("X-Forwarded-Host")
+	}
+	if host == "" {
+		host = r.Host
+	}
+
+	path, rawQuery := r.URL.EscapedPath(), r.URL.RawQuery
+	if u, err := url.ParseRequestURI(r.RequestURI); err == nil {
+		path, rawQuery = u.EscapedPath(), u.RawQuery
+	}
+
+	var params []string
+	for _, param := range strings.Split(rawQuery, "&") {
+		name := strings.SplitN(param, "=", 2)[0]
+		switch name {
+		case "", "Policy", "Signature", "Key-Pair-Id", "Expires":
+			continue
+		}
+		params = append(params, param)
+	}
+
+	resource := fmt.Sprintf("%s://%s%s", scheme, host, path)
+	if len(params) > 0 {
+		resource += "?" + strings.Join(params, "&")
+	}
+	return resource
+}
+
+// matchResource matches a policy resource where * matches any characters
+// and ? matches a single character
+func matchResource(pattern string, resource string) bool {
+	expr := regexp.QuoteMeta(pattern)
+	expr = strings.ReplaceAll(expr, `\*`, ".*")
+	expr = strings.ReplaceAll(expr, `\?`, ".")
+	matched, err := regexp.MatchString("^"+expr+"$", resource)
+	return err == nil && matched
+}
@@ -0,0 +1,187 @@
+package httpserver
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+type signedURLTunnel struct{}
+
+func (signedURLTunnel) Init()                                  {}
+func (signedURLTunnel) Close()                                 {}
+func (signedURLTunnel) Invoke(route string, req string) string { return "ok" }
+
+var cloudFrontDecoding = strings.NewReplacer("+", "-", "=", "_", "/", "~")
+
+func signCloudFront(t *testing.T, key *rsa.PrivateKey, policy string) string {
+	t.Helper()
+
+	hashed := sha1.Sum([]byte(policy))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA1, hashed[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cloudFrontDecoding.Replace(base64.StdEncoding.EncodeToString(sig))
+}
+
+func encodePolicy(policy string) string {
+	return cloudFrontDecoding.Replace(base64.StdEncoding.EncodeToString([]byte(policy)))
+}
+
+func newSignedURLEngine(t *testing.T, opts ...Option) (*Engine, *rsa.PrivateKey) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts = append([]Option{
+		WithStaticPackage("signed", "v1", signedURLTunnel{}),
+		WithSignedURLVerification(map[string]*rsa.PublicKey{"K1": &key.PublicKey}),
+	}, opts...)
+	return NewEngine(opts...), key
+}
+
+func serveSignedURL(e *Engine, target string, header http.Header) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	r.Host = "example.com"
+	for k, v := range header {
+		r.Header[k] = v
+	}
+	w := httptest.NewRecorder()
+	e.ServeHTTP(w, r)
+	return w
+}
+
+func TestSignedURLCanned(t *testing.T) {
+	e, key := newSignedURLEngine(t)
+
+	expires := time.Now().Add(time.Hour).Unix()
+	resource := "https://example.com/api/signed/v1/route?a=1"
+	signature := signCloudFront(t, key, fmt.Sprintf(`{"Statement":[{"Resource":"%s","Condition":{"DateLessThan":{"AWS:EpochTime":%d}}}]}`, resource, expires))
+
+	query := fmt.Sprintf("a=1&Expires=%d&Signature=%s&Key-Pair-Id=K1", expires, signature)
+	if w := serveSignedURL(e, "/api/signed/v1/route?"+query, nil); w.Code != http.StatusOK {
+		t.Fatalf("valid signature: got %d %s", w.Code, w.Body.String())
+	}
+
+	tampered := strings.Replace(query, "a=1", "a=2", 1)
+	if w := serveSignedURL(e, "/api/signed/v1/route?"+tampered, nil); w.Code != http.StatusForbidden {
+		t.Fatalf("tampered url: got %d", w.Code)
+	}
+
+	badSignature := fmt.Sprintf("a=1&Expires=%d&Signature=%s&Key-Pair-Id=K1", expires, "A"+signature[1:])
+	if w := serveSignedURL(e, "/api/signed/v1/route?"+badSignature, nil); w.Code != http.StatusForbidden {
+		t.Fatalf("tampered signature: got %d", w.Code)
+	}
+
+	if w := serveSignedURL(e, "/api/signed/v1/route", nil); w.Code != http.StatusForbidden {
+		t.Fatalf("missing signature: got %d", w.Code)
+	}
+}
+
+func TestSignedURLExpired(t *testing.T) {
+	e, key := newSignedURLEngine(t)
+
+	expires := time.Now().Add(-time.Minute).Unix()
+	resource := "https://example.com/api/signed/v1/route"
+	signature := signCloudFront(t, key, fmt.Sprintf(`{"Statement":[{"Resource":"%s","Condition":{"DateLessThan":{"AWS:EpochTime":%d}}}]}`, resource, expires))
+
+	w := serveSignedURL(e, fmt.Sprintf("/api/signed/v1/route?Expires=%d&Signature=%s&Key-Pair-Id=K1", expires, signature), nil)
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "expired") {
+		t.Fatalf("expired policy: got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestSignedURLCustomPolicyCookies(t *testing.T) {
+	e, key := newSignedURLEngine(t)
+
+	policy := fmt.Sprintf(`{"Statement":[{"Resource":"https://example.com/api/signed/*","Condition":{"DateLessThan":{"AWS:EpochTime":%d}}}]}`, time.Now().Add(time.Hour).Unix())
+	cookies := fmt.Sprintf("CloudFront-Policy=%s; CloudFront-Signature=%s; CloudFront-Key-Pair-Id=K1", encodePolicy(policy), signCloudFront(t, key, policy))
+
+	if w := serveSignedURL(e, "/api/signed/v1/route", http.Header{"Cookie": {cookies}}); w.Code != http.StatusOK {
+		t.Fatalf("signed cookies: got %d %s", w.Code, w.Body.String())
+	}
+	if w := serveSignedURL(e, "/wapi/other/v1/route", http.Header{"Cookie": {cookies}}); w.Code != http.StatusForbidden {
+		t.Fatalf("resource mismatch: got %d", w.Code)
+	}
+}
+
+func sourceIPQuery(t *testing.T, key *rsa.PrivateKey) string {
+	t.Helper()
+
+	policy := fmt.Sprintf(`{"Statement":[{"Resource":"https://example.com/api/signed/*","Condition":{"DateLessThan":{"AWS:EpochTime":%d},"IpAddress":{"AWS:SourceIp":"192.0.2.0/24"}}}]}`, time.Now().Add(time.Hour).Unix())
+	return fmt.Sprintf("Policy=%s&Signature=%s&Key-Pair-Id=K1", url.QueryEscape(encodePolicy(policy)), url.QueryEscape(signCloudFront(t, key, policy)))
+}
+
+func TestSignedURLSourceIP(t *testing.T) {
+	e, key := newSignedURLEngine(t)
+	query := sourceIPQuery(t, key)
+
+	do := func(remoteAddr string, forwardedFor string) int {
+		r := httptest.NewRequest(http.MethodGet, "/api/signed/v1/route?"+query, nil)
+		r.Host = "example.com"
+		r.RemoteAddr = remoteAddr
+		r.Header.Set("X-Forwarded-For", forwardedFor)
+		w := httptest.NewRecorder()
+		e.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	if code := do("192.0.2.10:1234", "198.51.100.7"); code != http.StatusOK {
+		t.Fatalf("allowed peer address: got %d", code)
+	}
+	if code := do("198.51.100.7:1234", "192.0.2.10"); code != http.StatusForbidden {
+		t.Fatalf("forwarded for without trusted hops: got %d", code)
+	}
+}
+
+func TestSignedURLTrustedHops(t *testing.T) {
+	e, key := newSignedURLEngine(t, WithSignedURLTrustedHops(1))
+	query := sourceIPQuery(t, key)
+
+	// CloudFront appends the viewer address to the header the viewer sent
+	if w := serveSignedURL(e, "/api/signed/v1/route?"+query, http.Header{"X-Forwarded-For": {"198.51.100.7, 192.0.2.10"}}); w.Code != http.StatusOK {
+		t.Fatalf("allowed source ip: got %d %s", w.Code, w.Body.String())
+	}
+	if w := serveSignedURL(e, "/api/signed/v1/route?"+query, http.Header{"X-Forwarded-For": {"192.0.2.10, 198.51.100.7"}}); w.Code != http.StatusForbidden {
+		t.Fatalf("spoofed leading entry: got %d", w.Code)
+	}
+	if w := serveSignedURL(e, "/api/signed/v1/route?"+query, nil); w.Code != http.StatusForbidden {
+		t.Fatalf("missing forwarded for: got %d", w.Code)
+	}
+}
+
+func TestSignedURLUnsupportedCondition(t *testing.T) {
+	e, key := newSignedURLEngine(t)
+
+	policy := fmt.Sprintf(`{"Statement":[{"Resource":"https://example.com/api/signed/*","Condition":{"DateLessThan":{"AWS:EpochTime":%d},"StringEquals":{"aws:Referer":"x"}}}]}`, time.Now().Add(time.Hour).Unix())
+	query := fmt.Sprintf("Policy=%s&Signature=%s&Key-Pair-Id=K1", url.QueryEscape(encodePolicy(policy)), url.QueryEscape(signCloudFront(t, key, policy)))
+
+	if w := serveSignedURL(e, "/api/signed/v1/route?"+query, nil); w.Code != http.StatusForbidden {
+		t.Fatalf("unsupported condition: got %d", w.Code)
+	}
+}
+
+func TestSignedURLBehindLink(t *testing.T) {
+	e, key := newSignedURLEngine(t, WithStaticLink("/short", "/api/signed/v1/route"), WithSignedURLHost("d111.cloudfront.net"))
+
+	expires := time.Now().Add(time.Hour).Unix()
+	resource := "https://d111.cloudfront.net/short"
+	signature := signCloudFront(t, key, fmt.Sprintf(`{"Statement":[{"Resource":"%s","Condition":{"DateLessThan":{"AWS:EpochTime":%d}}}]}`, resource, expires))
+
+	if w := serveSignedURL(e, fmt.Sprintf("/short?Expires=%d&Signature=%s&Key-Pair-Id=K1", expires, signature), nil); w.Code != http.StatusOK {
+		t.Fatalf("signed url behind link: got %d %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,53 @@
+package httpserver
+
+import (
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracePropagator = propagation.TraceContext{}
+
+// Tracing starts a span per request named after the matched route pattern,
+// continuing any trace carried by the incoming traceparent header
+func (e *Engine) Tracing(c *gin.Context) {
+	if e.Tracer == nil {
+		return
+	}
+
+	spanName := c.FullPath()
+	if spanName == "" {
+		spanName = "unmatched"
+	}
+
+	ctx := tracePropagator.Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+	ctx, span := e.Tracer.Start(ctx, spanName, trace.WithSpanKind(trace.SpanKindServer))
+	defer span.End()
+	c.Request = c.Request.WithContext(ctx)
+
+	c.Next()
+
+	if packageName, commit, route, err := parsePath(c.GetString(PathContext)); err == nil {
+		span.SetAttributes(
+			attribute.String("lambda.package", packageName),
+			attribute.String("lambda.version", commit),
+			attribute.String("lambda.route", route),
+		)
+	}
+	if id := c.GetString(RequestIDContext); id != "" {
+		span.SetAttributes(attribute.String("lambda.request_id", id))
+	}
+	span.SetAttributes(attribute.Int("http.status_code", c.Writer.Status()))
+
+	if v, ok := c.Get(PanicContext); ok && v != nil {
+		span.RecordError(v.(error))
+		span.SetStatus(codes.Error, v.(error).Error())
+	} else if v, ok := c.Get(ErrorContext); ok && v != nil {
+		span.RecordError(v.(error))
+		span.SetStatus(codes.Error, v.(error).Error())
+	} else if c.Writer.Status() >= 500 {
+		span.SetStatus(codes.Error, "")
+	}
+}
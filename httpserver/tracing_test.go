@@ -0,0 +1,97 @@
+package httpserver
+
+import (
+	"net/http"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func spanAttributes(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	attrs := map[attribute.Key]attribute.Value{}
+	for _, kv := range span.Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	return attrs
+}
+
+func TestTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	pkg := testPackage(t)
+	e := NewEngine(
+		WithStaticPackage(pkg, "v1", funcTunnel(func(route string, req string) string { return "ok" })),
+		WithTracing(provider.Tracer("test")),
+	)
+
+	traceparent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	w := serve(e, http.MethodGet, "/api/"+pkg+"/v1/users/42", "", http.Header{"Traceparent": {traceparent}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d %s", w.Code, w.Body.String())
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans", len(spans))
+	}
+	invoke, server := spans[0], spans[1]
+
+	if server.Name() != "/api/*path" {
+		t.Errorf("server span name %q", server.Name())
+	}
+	if server.SpanKind() != trace.SpanKindServer {
+		t.Errorf("server span kind %v", server.SpanKind())
+	}
+	if got := server.Parent().TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("server span not linked to traceparent, trace id %s", got)
+	}
+	attrs := spanAttributes(server)
+	for key, want := range map[attribute.Key]attribute.Value{
+		"lambda.package":   attribute.StringValue(pkg),
+		"lambda.version":   attribute.StringValue("v1"),
+		"lambda.route":     attribute.StringValue("/users/42"),
+		"http.status_code": attribute.IntValue(http.StatusOK),
+	} {
+		if attrs[key] != want {
+			t.Errorf("server span %s = %v, want %v", key, attrs[key].Emit(), want.Emit())
+		}
+	}
+
+	if invoke.Name() != "invoke" {
+		t.Errorf("invoke span name %q", invoke.Name())
+	}
+	if invoke.Parent().SpanID() != server.SpanContext().SpanID() {
+		t.Error("invoke span is not a child of the server span")
+	}
+	if attrs := spanAttributes(invoke); attrs["lambda.package"] != attribute.StringValue(pkg) {
+		t.Errorf("invoke span package %v", attrs["lambda.package"].Emit())
+	}
+}
+
+func TestTracingRecordsError(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	pkg := testPackage(t)
+	e := NewEngine(
+		WithStaticPackage(pkg, "v1", funcTunnel(func(route string, req string) string { panic("boom") })),
+		WithTracing(provider.Tracer("test")),
+	)
+
+	if w := serve(e, http.MethodGet, "/api/"+pkg+"/v1/route", "", nil); w.Code != http.StatusInternalServerError {
+		t.Fatalf("got %d", w.Code)
+	}
+
+	spans := recorder.Ended()
+	server := spans[len(spans)-1]
+	if server.Status().Description != "panic: boom" {
+		t.Errorf("server span status %+v", server.Status())
+	}
+	if len(server.Events()) == 0 || server.Events()[0].Name != "exception" {
+		t.Errorf("server span has no exception event")
+	}
+}